@@ -50,18 +50,6 @@ func LoadCast(path string, width int, height int, th int, tw int) (c *Cast, err
 	return
 }
 
-func (c *Cast) Overlaps(a *Actor, b *Actor) bool {
-	var (
-		w    = float64(c.Width - c.OffsetX)
-		h    = float64(c.Height - c.OffsetY)
-		xmin = a.x >= b.x && a.x <= b.x+w
-		xmax = a.x+w >= b.x && a.x+w <= b.x+w
-		ymin = a.y >= b.y && a.y <= b.y+h
-		ymax = a.y+h >= b.y && a.y+h <= b.y+h
-	)
-	return (xmin || xmax) && (ymin || ymax)
-}
-
 func (c *Cast) AddActor(a system.Drawable) {
 	if a == nil {
 		return
@@ -83,31 +71,103 @@ func (c *Cast) RemoveActor(a system.Drawable) {
 
 func (c *Cast) Update(level *Level, diff time.Duration) {
 	sort.Sort(system.ByY{c.Actors})
-	for _, a := range ACTOR_ANIMATIONS {
-		a.Next()
+	for _, a := range c.Actors {
+		if anim, ok := a.(Animated); ok {
+			anim.Animate(diff)
+		}
 	}
 }
 
-type Actor struct {
-	x          float64
-	y          float64
-	State      int
-	textureRow int
-	flipX      bool
-	Rate       float64
-	Padding    int
-	Bomb       *Bomb
+// Actors which play out a death animation before leaving the cast.
+type Mortal interface {
+	system.Drawable
+	Die()
+	Finished() bool
+}
+
+// Drawables which keep their own animation state.
+type Animated interface {
+	Animate(diff time.Duration)
+}
+
+// A rectangle in pixels.  Actors keep theirs relative to their position.
+type Hitbox struct {
+	X float64
+	Y float64
+	W float64
+	H float64
+}
+
+func (h Hitbox) Area() float64 {
+	return h.W * h.H
+}
+
+// Returns the area shared by two boxes.
+func (h Hitbox) Intersect(o Hitbox) float64 {
+	var (
+		w = math.Min(h.X+h.W, o.X+o.W) - math.Max(h.X, o.X)
+		v = math.Min(h.Y+h.H, o.Y+o.H) - math.Max(h.Y, o.Y)
+	)
+	if w <= 0 || v <= 0 {
+		return 0
+	}
+	return w * v
+}
+
+func (h Hitbox) Overlaps(o Hitbox) bool {
+	return h.Intersect(o) > 0
 }
 
-func NewActor(x float64, y float64, state int, textureRow int) *Actor {
+var (
+	TILE_HITBOX   = Hitbox{0, 0, 32, 32}
+	PLAYER_HITBOX = Hitbox{6, 8, 20, 20}
+	ENEMY_HITBOX  = Hitbox{6, 8, 20, 20}
+	GOAL_HITBOX   = Hitbox{10, 10, 12, 12}
+)
+
+type Actor struct {
+	x            float64
+	y            float64
+	State        int
+	sprite       string
+	flipX        bool
+	Rate         float64 // Pixels per second
+	Padding      int
+	PassesBricks bool
+	Bomb         *Bomb
+	Hitbox       Hitbox
+	anim         *system.AnimationPlayer
+}
+
+func NewActor(x float64, y float64, state int, sprite string) *Actor {
 	return &Actor{
-		x:          x,
-		y:          y,
-		State:      state,
-		textureRow: textureRow,
+		x:      x,
+		y:      y,
+		State:  state,
+		sprite: sprite,
+		Hitbox: TILE_HITBOX,
+	}
+}
+
+// Returns the actor's hitbox in level coordinates.
+func (a *Actor) Bounds() Hitbox {
+	return a.boundsAt(a.x, a.y)
+}
+
+// Returns where the actor's hitbox would be if it stood at x, y.
+func (a *Actor) boundsAt(x float64, y float64) Hitbox {
+	return Hitbox{
+		X: x + a.Hitbox.X,
+		Y: y + a.Hitbox.Y,
+		W: a.Hitbox.W,
+		H: a.Hitbox.H,
 	}
 }
 
+func (a *Actor) Overlaps(b *Actor) bool {
+	return a.Bounds().Overlaps(b.Bounds())
+}
+
 func (a *Actor) X() float64 {
 	return a.x
 }
@@ -129,43 +189,103 @@ func (a *Actor) FlipX() bool {
 }
 
 func (a *Actor) TextureRow() int {
-	return a.textureRow
+	if sprite, ok := SPRITES[a.sprite]; ok {
+		return sprite.TextureRow
+	}
+	return 0
 }
 
-func (a *Actor) Update(level *Level) bool {
+func (a *Actor) Update(level *Level, diff time.Duration) bool {
 	if !a.TestState(WALKING) {
 		return true
 	}
+	var dist = a.getDistance(diff)
 	switch {
 	case a.TestState(DOWN):
-		a.moveDown(level)
+		a.moveDown(level, dist)
 	case a.TestState(UP):
-		a.moveUp(level)
+		a.moveUp(level, dist)
 	case a.TestState(RIGHT):
-		a.moveRight(level)
+		a.moveRight(level, dist)
 	case a.TestState(LEFT):
-		a.moveLeft(level)
+		a.moveLeft(level, dist)
 	}
 	return true
 }
 
+// How far the actor travels at its Rate over the given time.
+func (a *Actor) getDistance(diff time.Duration) float64 {
+	return a.Rate * diff.Seconds()
+}
+
 func (a *Actor) GetFrame() int {
+	if a.anim != nil {
+		return a.anim.Curr()
+	}
+	if anim := a.getAnimation(); anim != nil {
+		return anim.Frames[0].Index
+	}
+	return 0
+}
+
+// Advances the actor's animation, switching to a new one if its state
+// changed.
+func (a *Actor) Animate(diff time.Duration) {
+	var anim = a.getAnimation()
+	if anim == nil {
+		return
+	}
+	if a.anim == nil {
+		a.anim = system.NewAnimationPlayer(anim)
+	} else {
+		a.anim.Play(anim)
+	}
+	a.anim.Update(diff)
+}
+
+func (a *Actor) getAnimation() (anim *system.Animation) {
+	var (
+		sprite *Sprite
+		ok     bool
+	)
+	if sprite, ok = SPRITES[a.sprite]; !ok {
+		log.Printf("No sprite %v", a.sprite)
+		return nil
+	}
+	if anim, ok = sprite.Anims[a.State]; !ok {
+		sprite.reportMissing(a.sprite, a.State)
+		anim = sprite.Anims[LEFT|STOPPED]
+	}
+	return
+}
+
+// Switches to the DYING state, dropping direction and movement.
+func (a *Actor) Die() {
+	a.State = DYING
+}
+
+// Whether the animation for the current state has played to the end.
+// States without a one-shot animation count as finished straight away.
+func (a *Actor) Finished() bool {
 	var (
-		anim *system.Animation
-		ok   bool
+		sprite *Sprite
+		anim   *system.Animation
+		ok     bool
 	)
-	if anim, ok = ACTOR_ANIMATIONS[a.State]; !ok {
-		log.Printf("No animation for state %v", a.State)
-		anim = ACTOR_ANIMATIONS[LEFT|STOPPED]
+	if sprite, ok = SPRITES[a.sprite]; !ok {
+		return true
+	}
+	if anim, ok = sprite.Anims[a.State]; !ok || anim.Mode != system.AnimOnce {
+		return true
 	}
-	return anim.Curr()
+	return a.anim != nil && a.anim.Anim == anim && a.anim.Done
 }
 
 func (a *Actor) TestState(state int) bool {
 	return a.State&state == state
 }
 
-const UNSET_MASK = 1<<10 - 1
+const UNSET_MASK = 1<<13 - 1
 
 func (a *Actor) UnsetState(mask int) {
 	a.State &= UNSET_MASK ^ mask
@@ -176,87 +296,126 @@ func (a *Actor) SetState(mask int) {
 }
 
 // Attempts to round X or Y values to tile boundaries if they're within Padding
-func (a *Actor) getClamped(v float64, size int) int {
+func (a *Actor) getClamped(v float64, size int) float64 {
 	var (
 		clamped = math.Floor(v/float64(size)+0.5) * float64(size)
 		diff    = math.Abs(clamped - v)
 	)
-	if int(diff) <= a.Padding {
-		return int(clamped)
+	if diff <= float64(a.Padding) {
+		return clamped
 	}
-	return int(v)
+	return v
 }
 
-func (a *Actor) moveDown(l *Level) {
+// Positions stay fractional, only the collision probes are whole pixels.
+// The probes sit on the edge of the hitbox, so walls stop the box rather
+// than the whole tile the actor is drawn in.
+func (a *Actor) moveDown(l *Level, dist float64) {
 	var (
-		x int
-		y int
+		x = a.getClamped(a.x, l.TileWidth)
+		y = a.y + dist
+		b = a.boundsAt(x, y)
 	)
-	x = a.getClamped(a.x, l.TileWidth)
-	y = int(a.y + a.Rate)
-	if l.TestPixelPassable(a, x+a.Padding, y+l.TileHeight) &&
-		l.TestPixelPassable(a, x+l.TileWidth-a.Padding, y+l.TileHeight) {
-		if x == int(a.x) {
+	if l.TestPixelPassable(a, int(b.X), int(b.Y+b.H)) &&
+		l.TestPixelPassable(a, int(b.X+b.W)-1, int(b.Y+b.H)) {
+		if x == a.x {
 			// Only move once we've clamped.
-			a.y = float64(y)
+			a.y = y
 		}
-		a.x = float64(x)
+		a.x = x
 	}
 }
 
-func (a *Actor) moveUp(l *Level) {
+func (a *Actor) moveUp(l *Level, dist float64) {
 	var (
-		x int
-		y int
+		x = a.getClamped(a.x, l.TileWidth)
+		y = a.y - dist
+		b = a.boundsAt(x, y)
 	)
-	x = a.getClamped(a.x, l.TileWidth)
-	y = int(a.y - a.Rate)
-	if l.TestPixelPassable(a, x+a.Padding, y) &&
-		l.TestPixelPassable(a, x+l.TileWidth-a.Padding, y) {
-		if x == int(a.x) {
+	if l.TestPixelPassable(a, int(b.X), int(b.Y)) &&
+		l.TestPixelPassable(a, int(b.X+b.W)-1, int(b.Y)) {
+		if x == a.x {
 			// Only move once we've clamped.
-			a.y = float64(y)
+			a.y = y
 		}
-		a.x = float64(x)
+		a.x = x
 	}
 }
 
-func (a *Actor) moveRight(l *Level) {
+func (a *Actor) moveRight(l *Level, dist float64) {
 	var (
-		x int
-		y int
+		y = a.getClamped(a.y, l.TileHeight)
+		x = a.x + dist
+		b = a.boundsAt(x, y)
 	)
-	y = a.getClamped(a.y, l.TileHeight)
-	x = int(a.x + a.Rate)
-	if l.TestPixelPassable(a, x+l.TileWidth, y+a.Padding) &&
-		l.TestPixelPassable(a, x+l.TileWidth, y+l.TileHeight-a.Padding) {
-		if y == int(a.y) {
+	if l.TestPixelPassable(a, int(b.X+b.W), int(b.Y)) &&
+		l.TestPixelPassable(a, int(b.X+b.W), int(b.Y+b.H)-1) {
+		if y == a.y {
 			// Only move once we've clamped.
-			a.x = float64(x)
+			a.x = x
 		}
-		a.y = float64(y)
+		a.y = y
 	}
 }
 
-func (a *Actor) moveLeft(l *Level) {
+func (a *Actor) moveLeft(l *Level, dist float64) {
 	var (
-		x int
-		y int
+		y = a.getClamped(a.y, l.TileHeight)
+		x = a.x - dist
+		b = a.boundsAt(x, y)
 	)
-	y = a.getClamped(a.y, l.TileHeight)
-	x = int(a.x - a.Rate)
-	if l.TestPixelPassable(a, x, y+a.Padding) &&
-		l.TestPixelPassable(a, x, y+l.TileHeight-a.Padding) {
-		if y == int(a.y) {
+	if l.TestPixelPassable(a, int(b.X), int(b.Y)) &&
+		l.TestPixelPassable(a, int(b.X), int(b.Y+b.H)-1) {
+		if y == a.y {
 			// Only move once we've clamped.
-			a.x = float64(x)
+			a.x = x
 		}
-		a.y = float64(y)
+		a.y = y
 	}
 }
 
 type Player struct {
 	*Actor
+	Powers   int
+	Carrying *Bomb
+	Health   int
+	Index    int // Which player this is, or -1 for enemies and bosses
+	cooldown time.Duration
+	held     []int
+	queued   int
+}
+
+func (p *Player) Update(level *Level, diff time.Duration) bool {
+	if p.queued != 0 && p.aligned(level, p.queued) {
+		p.SetDirection(p.queued)
+		p.queued = 0
+	}
+	if p.TestState(WALKING) && p.HasPower(POWER_KICK) {
+		if b := level.getBombAhead(p.Actor); b != nil && b != p.Bomb {
+			level.KickBomb(b, p.State&(LEFT|RIGHT|UP|DOWN))
+		}
+	}
+	return p.Actor.Update(level, diff)
+}
+
+func (p *Player) HasPower(power int) bool {
+	return p.Powers&power == power
+}
+
+// Applies damage unless the player was hurt very recently.  Returns true
+// once the player is out of health.
+func (p *Player) Hurt(damage int) bool {
+	if p.cooldown <= 0 && damage > 0 {
+		p.Health -= damage
+		p.cooldown = HURT_COOLDOWN
+	}
+	return p.Health <= 0
+}
+
+func (p *Player) AddTime(diff time.Duration) {
+	if p.cooldown > 0 {
+		p.cooldown -= diff
+	}
 }
 
 func (p *Player) SetDirection(dir int) {
@@ -274,63 +433,159 @@ func (p *Player) SetMovement(mov int) {
 	p.SetState(mov)
 }
 
-func NewPlayer(x float64, y float64, state int, offset int) (p *Player) {
+// Held directions form a stack, so letting go of one resumes the most
+// recent direction still held.
+func (p *Player) PressDirection(l *Level, dir int) {
+	p.removeHeld(dir)
+	p.held = append(p.held, dir)
+	p.turn(l, dir)
+}
+
+func (p *Player) ReleaseDirection(l *Level, dir int) {
+	p.removeHeld(dir)
+	p.FollowHeld(l)
+}
+
+// Keeps track of a direction without moving, for while the player can't.
+func (p *Player) HoldDirection(dir int, pressed bool) {
+	p.removeHeld(dir)
+	if pressed {
+		p.held = append(p.held, dir)
+	}
+}
+
+// Walks in the direction held most recently, or stops if none are.
+func (p *Player) FollowHeld(l *Level) {
+	if len(p.held) == 0 {
+		p.queued = 0
+		p.SetMovement(STOPPED)
+		return
+	}
+	p.turn(l, p.held[len(p.held)-1])
+}
+
+// Forgets every held direction, e.g. after the player is moved.
+func (p *Player) ClearDirections() {
+	p.held = nil
+	p.queued = 0
+}
+
+func (p *Player) removeHeld(dir int) {
+	for i, d := range p.held {
+		if d == dir {
+			p.held = append(p.held[:i], p.held[i+1:]...)
+			break
+		}
+	}
+}
+
+// Starts walking in dir.  Turning onto the other axis waits until the
+// player lines up with the grid, otherwise they'd just walk into a wall.
+func (p *Player) turn(l *Level, dir int) {
+	var crossing = (dir&(UP|DOWN) != 0) != (p.State&(UP|DOWN) != 0)
+	p.queued = 0
+	if p.TestState(WALKING) && crossing && !p.aligned(l, dir) {
+		p.queued = dir
+		return
+	}
+	p.SetDirection(dir)
+	p.SetMovement(WALKING)
+}
+
+// Whether the player is close enough to the grid to move in dir, allowing
+// for Padding.
+func (p *Player) aligned(l *Level, dir int) bool {
+	if dir&(UP|DOWN) != 0 {
+		return math.Mod(p.getClamped(p.x, l.TileWidth), float64(l.TileWidth)) == 0
+	}
+	return math.Mod(p.getClamped(p.y, l.TileHeight), float64(l.TileHeight)) == 0
+}
+
+const MAX_PLAYERS = 4
+
+// Each player's sprite, by index.
+var PLAYER_SPRITES = [MAX_PLAYERS]string{"player", "player2", "player3", "player4"}
+
+func NewPlayer(x float64, y float64, state int, sprite string) (p *Player) {
 	return &Player{
 		Actor: &Actor{
-			x:          x,
-			y:          y,
-			State:      state,
-			textureRow: offset,
-			Rate:       2.0,
-			Padding:    12,
+			x:       x,
+			y:       y,
+			State:   state,
+			sprite:  sprite,
+			Rate:    120.0,
+			Padding: 12,
+			Hitbox:  PLAYER_HITBOX,
 		},
+		Health: 1,
 	}
 }
 
 type Enemy struct {
 	*Player
-	Target *Tile
-	rng    *rand.Rand
-	tX     float64
-	tY     float64
-}
-
-func NewEnemy(x float64, y float64, state int) *Enemy {
+	Behavior   Behavior
+	Target     *Tile
+	DropsBombs bool
+	Damage     int
+	rng        *rand.Rand
+	tX         float64
+	tY         float64
+}
+
+func NewEnemy(x float64, y float64, state int, arch *EnemyArchetype, rng *rand.Rand) *Enemy {
+	var hitbox = ENEMY_HITBOX
+	if arch.Hitbox != nil {
+		hitbox = *arch.Hitbox
+	}
 	return &Enemy{
 		Player: &Player{
 			Actor: &Actor{
-				x:          x,
-				y:          y,
-				State:      state,
-				textureRow: 3,
-				Rate:       1.0,
-				Padding:    12,
+				x:            x,
+				y:            y,
+				State:        state,
+				sprite:       arch.Sprite,
+				Rate:         arch.Rate,
+				Padding:      arch.Padding,
+				PassesBricks: arch.PassesBricks,
+				Hitbox:       hitbox,
 			},
+			Health: arch.Health,
+			Index:  -1,
 		},
-		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
-		Target: nil,
+		rng:        rng,
+		Behavior:   &WanderBehavior{},
+		Target:     nil,
+		DropsBombs: arch.DropsBombs,
+		Damage:     arch.Damage,
 	}
 }
 
-func (e *Enemy) Update(l *Level) {
+func (e *Enemy) Update(l *Level, diff time.Duration) {
+	var i = l.getActorIndex(e.Player.Actor)
+	if e.Target != nil && l.danger[l.xyToI(e.Target.X, e.Target.Y)] != SAFE &&
+		l.danger[i] == SAFE {
+		// Don't walk into a blast, head back to safety.
+		e.setTarget(l, &l.tiles[i])
+	}
 	if e.Target == nil {
-		if e.rng.Float32() > 0.9 {
-			l.AddBombFromActor(e.Player.Actor)
+		if e.DropsBombs && e.rng.Float32() > 0.9 && l.CanEscapeBomb(e.Player.Actor) {
+			l.AddBombFromPlayer(e.Player)
+			l.danger = l.GetDangerMap()
+		}
+		if e.setTarget(l, e.chooseTarget(l, i)); e.Target == nil {
+			e.SetMovement(STOPPED)
+			return
 		}
-		opts := l.GetMovementOptions(e.Player.Actor)
-		e.Target = opts[e.rng.Intn(len(opts))]
-		tX, tY := l.TileToPixel(e.Target)
-		e.tX = float64(tX)
-		e.tY = float64(tY)
 	}
 	var (
-		dX = math.Abs(e.X() - e.tX)
-		dY = math.Abs(e.Y() - e.tY)
+		dX   = math.Abs(e.X() - e.tX)
+		dY   = math.Abs(e.Y() - e.tY)
+		dist = e.getDistance(diff)
 	)
-	if dX <= e.Player.Actor.Rate && dX > 0 {
+	if dX <= dist && dX > 0 {
 		e.SetX(e.tX)
 		return
-	} else if dY <= e.Player.Actor.Rate && dY > 0 {
+	} else if dY <= dist && dY > 0 {
 		e.SetY(e.tY)
 		return
 	} else if dY == 0 && dX == 0 {
@@ -340,40 +595,76 @@ func (e *Enemy) Update(l *Level) {
 	if e.tX > e.X() {
 		e.SetDirection(RIGHT)
 		e.SetMovement(WALKING)
-		e.moveRight(l)
+		e.moveRight(l, dist)
 	} else if e.tX < e.X() {
 		e.SetDirection(LEFT)
 		e.SetMovement(WALKING)
-		e.moveLeft(l)
+		e.moveLeft(l, dist)
 	} else if e.tY > e.Y() {
 		e.SetDirection(DOWN)
 		e.SetMovement(WALKING)
-		e.moveDown(l)
+		e.moveDown(l, dist)
 	} else if e.tY < e.Y() {
 		e.SetDirection(UP)
 		e.SetMovement(WALKING)
-		e.moveUp(l)
+		e.moveUp(l, dist)
 	} else {
 		e.SetMovement(STOPPED)
 		e.Target = nil
 	}
 }
 
+func (e *Enemy) setTarget(l *Level, t *Tile) {
+	e.Target = t
+	if t != nil {
+		tX, tY := l.TileToPixel(t)
+		e.tX = float64(tX)
+		e.tY = float64(tY)
+	}
+}
+
+// Picks the next tile to walk to from tile index i, running from any blast
+// about to go off and otherwise deferring to the enemy's Behavior.
+func (e *Enemy) chooseTarget(l *Level, i int) *Tile {
+	var (
+		t    *Tile
+		path []int
+	)
+	if l.danger[i] != SAFE {
+		if path = l.FindEscape(e.Player.Actor, i, l.danger); len(path) == 0 {
+			return nil
+		}
+		return &l.tiles[path[0]]
+	}
+	if t = e.Behavior.Next(e, l); t != nil && l.danger[l.xyToI(t.X, t.Y)] != SAFE {
+		return nil
+	}
+	return t
+}
+
 type Bomb struct {
 	*Actor
 	Elapsed time.Duration
 	Expires time.Duration
 	Radius  int
+	Sliding int
+	Flying  bool
+	carrier *Player
+	owner   *Player
+	heading int
+	tX      float64
+	tY      float64
 }
 
 func NewBomb(x float64, y float64) (b *Bomb) {
 	return &Bomb{
 		Actor: &Actor{
-			x:          x,
-			y:          y,
-			State:      BOMB,
-			textureRow: 1,
-			Padding:    12,
+			x:       x,
+			y:       y,
+			State:   BOMB,
+			sprite:  "bomb",
+			Padding: 12,
+			Hitbox:  TILE_HITBOX,
 		},
 		Elapsed: 0,
 		Expires: time.Duration(3) * time.Second,
@@ -393,6 +684,64 @@ func (b *Bomb) AddTime(diff time.Duration) {
 	b.Elapsed += diff
 }
 
+// Time left until the bomb goes off.
+func (b *Bomb) Remaining() time.Duration {
+	if b.Elapsed >= b.Expires {
+		return 0
+	}
+	return b.Expires - b.Elapsed
+}
+
+// Moves a thrown bomb towards its landing tile, wrapping around the edges
+// of the level.  Returns true once the bomb has arrived.
+func (b *Bomb) Fly(level *Level, diff time.Duration) bool {
+	var (
+		dx, dy = getDirectionStep(b.heading)
+		w      = float64(level.Map.Width * level.TileWidth)
+		h      = float64(level.Map.Height * level.TileHeight)
+		step   = BOMB_THROW_RATE * diff.Seconds()
+		left   float64
+	)
+	if dx != 0 {
+		left = math.Mod((b.tX-b.x)*float64(dx)+w, w)
+	} else {
+		left = math.Mod((b.tY-b.y)*float64(dy)+h, h)
+	}
+	if left <= step {
+		b.x = b.tX
+		b.y = b.tY
+		return true
+	}
+	b.x = math.Mod(b.x+float64(dx)*step+w, w)
+	b.y = math.Mod(b.y+float64(dy)*step+h, h)
+	return false
+}
+
+type Pickup struct {
+	*Actor
+	Power int
+}
+
+// Each power's pickup uses the sprite named after it, e.g. "pickup_kick".
+func NewPickup(x float64, y float64, power int) *Pickup {
+	var sprite = "pickup"
+	for name, p := range POWERS {
+		if p == power {
+			sprite = "pickup_" + name
+		}
+	}
+	return &Pickup{
+		Actor: &Actor{
+			x:      x,
+			y:      y,
+			State:  PICKUP,
+			sprite: sprite,
+			Hitbox: TILE_HITBOX,
+		},
+		Power: power,
+	}
+}
+
 type Fire struct {
 	*Actor
 	Elapsed time.Duration
@@ -402,10 +751,11 @@ type Fire struct {
 func NewFire(x float64, y float64) (f *Fire) {
 	return &Fire{
 		Actor: &Actor{
-			x:          x,
-			y:          y,
-			State:      FLAME,
-			textureRow: 2,
+			x:      x,
+			y:      y,
+			State:  FLAME,
+			sprite: "flame",
+			Hitbox: TILE_HITBOX,
 		},
 		Elapsed: 0,
 		Expires: time.Duration(250) * time.Millisecond,
@@ -425,42 +775,53 @@ func (f *Fire) Update(level *Level) bool {
 }
 
 const (
-	LEFT    = 1 << iota
-	RIGHT   = 1 << iota
-	UP      = 1 << iota
-	DOWN    = 1 << iota
-	WALKING = 1 << iota
-	STOPPED = 1 << iota
-	BOMB    = 1 << iota
-	FLAME   = 1 << iota
-	GOAL    = 1 << iota
+	LEFT     = 1 << iota
+	RIGHT    = 1 << iota
+	UP       = 1 << iota
+	DOWN     = 1 << iota
+	WALKING  = 1 << iota
+	STOPPED  = 1 << iota
+	BOMB     = 1 << iota
+	FLAME    = 1 << iota
+	GOAL     = 1 << iota
+	PICKUP   = 1 << iota
+	DYING    = 1 << iota
+	VICTORY  = 1 << iota
+	PARTICLE = 1 << iota
 )
 
-var ACTOR_ANIMATIONS = map[int]*system.Animation{
-	LEFT | STOPPED:                   system.Anim([]int{6}, 4),
-	RIGHT | STOPPED:                  system.Anim([]int{6}, 4),
-	UP | STOPPED:                     system.Anim([]int{3}, 4),
-	DOWN | STOPPED:                   system.Anim([]int{0}, 4),
-	LEFT | WALKING:                   system.Anim([]int{6, 7, 6, 8}, 4),
-	RIGHT | WALKING:                  system.Anim([]int{6, 7, 6, 8}, 4),
-	UP | WALKING:                     system.Anim([]int{3, 4, 3, 5}, 4),
-	DOWN | WALKING:                   system.Anim([]int{0, 1, 0, 2}, 4),
-	BOMB:                             system.Anim([]int{0, 1}, 4),
-	GOAL:                             system.Anim([]int{2}, 4),
-	FLAME:                            system.Anim([]int{0}, 4),
-	FLAME | UP:                       system.Anim([]int{1}, 4),
-	FLAME | DOWN:                     system.Anim([]int{2}, 4),
-	FLAME | LEFT:                     system.Anim([]int{3}, 4),
-	FLAME | RIGHT:                    system.Anim([]int{4}, 4),
-	FLAME | UP | LEFT:                system.Anim([]int{5}, 4),
-	FLAME | UP | RIGHT:               system.Anim([]int{6}, 4),
-	FLAME | DOWN | RIGHT:             system.Anim([]int{7}, 4),
-	FLAME | DOWN | LEFT:              system.Anim([]int{8}, 4),
-	FLAME | DOWN | LEFT | RIGHT:      system.Anim([]int{9}, 4),
-	FLAME | UP | DOWN | LEFT:         system.Anim([]int{10}, 4),
-	FLAME | UP | LEFT | RIGHT:        system.Anim([]int{11}, 4),
-	FLAME | UP | RIGHT | DOWN:        system.Anim([]int{12}, 4),
-	FLAME | UP | RIGHT | DOWN | LEFT: system.Anim([]int{13}, 4),
-	FLAME | RIGHT | LEFT:             system.Anim([]int{14}, 4),
-	FLAME | UP | DOWN:                system.Anim([]int{15}, 4),
+const (
+	POWER_KICK  = 1 << iota
+	POWER_THROW = 1 << iota
+)
+
+// Names used for states in data/animations.json.
+var STATES = map[string]int{
+	"left":     LEFT,
+	"right":    RIGHT,
+	"up":       UP,
+	"down":     DOWN,
+	"walking":  WALKING,
+	"stopped":  STOPPED,
+	"bomb":     BOMB,
+	"flame":    FLAME,
+	"goal":     GOAL,
+	"pickup":   PICKUP,
+	"dying":    DYING,
+	"victory":  VICTORY,
+	"particle": PARTICLE,
+}
+
+var POWERS = map[string]int{
+	"kick":  POWER_KICK,
+	"throw": POWER_THROW,
 }
+
+const HURT_COOLDOWN = time.Second
+
+// Rates are in pixels per second.
+const (
+	BOMB_SLIDE_RATE     = 240.0
+	BOMB_THROW_RATE     = 480.0
+	BOMB_THROW_DISTANCE = 3
+)
@@ -0,0 +1,147 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Decides where an enemy walks next.
+type Behavior interface {
+	// Returns the adjacent tile to walk to, or nil to stand still.
+	Next(e *Enemy, l *Level) *Tile
+}
+
+// Walks to a random adjacent tile.
+type WanderBehavior struct{}
+
+func (b *WanderBehavior) Next(e *Enemy, l *Level) *Tile {
+	opts := l.GetMovementOptions(e.Player.Actor)
+	if len(opts) == 0 {
+		return nil
+	}
+	return opts[e.rng.Intn(len(opts))]
+}
+
+// Walks towards the player once they're within Range tiles.
+type ChaseBehavior struct {
+	Range  int
+	wander WanderBehavior
+}
+
+func (b *ChaseBehavior) Next(e *Enemy, l *Level) *Tile {
+	var (
+		start = l.getActorIndex(e.Player.Actor)
+		goal  = l.getActorIndex(l.Player.Actor)
+		path  []int
+	)
+	if l.TileDistance(start, goal) <= b.Range {
+		path = l.FindPath(e.Player.Actor, start, goal)
+	}
+	if len(path) == 0 || len(path) > b.Range {
+		return b.wander.Next(e, l)
+	}
+	return &l.tiles[path[0]]
+}
+
+// Walks between a list of waypoints in order, looping at the end.
+type PatrolBehavior struct {
+	Waypoints []int
+	curr      int
+	wander    WanderBehavior
+}
+
+func (b *PatrolBehavior) Next(e *Enemy, l *Level) *Tile {
+	var (
+		start = l.getActorIndex(e.Player.Actor)
+		path  []int
+	)
+	if len(b.Waypoints) == 0 {
+		return b.wander.Next(e, l)
+	}
+	if start == b.Waypoints[b.curr] {
+		b.curr = (b.curr + 1) % len(b.Waypoints)
+	}
+	if path = l.FindPath(e.Player.Actor, start, b.Waypoints[b.curr]); len(path) == 0 {
+		// Waypoint is blocked for now, try the next one.
+		b.curr = (b.curr + 1) % len(b.Waypoints)
+		return b.wander.Next(e, l)
+	}
+	return &l.tiles[path[0]]
+}
+
+// Runs to the reachable tile furthest from the player once they're within
+// Range tiles.
+type FleeBehavior struct {
+	Range  int
+	wander WanderBehavior
+}
+
+func (b *FleeBehavior) Next(e *Enemy, l *Level) *Tile {
+	var (
+		start     = l.getActorIndex(e.Player.Actor)
+		player    = l.getActorIndex(l.Player.Actor)
+		best      = start
+		prev, all = l.SearchTiles(e.Player.Actor, start)
+		path      []int
+	)
+	if l.TileDistance(start, player) > b.Range {
+		return b.wander.Next(e, l)
+	}
+	for _, i := range all {
+		if l.TileDistance(i, player) > l.TileDistance(best, player) {
+			best = i
+		}
+	}
+	if path = getPath(prev, start, best); len(path) == 0 {
+		return nil
+	}
+	return &l.tiles[path[0]]
+}
+
+const DEFAULT_BEHAVIOR_RANGE = 6
+
+// Builds the behavior configured by the "behavior", "range" and "route"
+// properties of a map object.
+func (l *Level) getBehavior(obj map[string]string) (b Behavior, err error) {
+	var (
+		r   = DEFAULT_BEHAVIOR_RANGE
+		raw string
+		ok  bool
+	)
+	if raw, ok = obj["range"]; ok {
+		if r, err = strconv.Atoi(raw); err != nil {
+			return
+		}
+	}
+	switch obj["behavior"] {
+	case "", "wander":
+		b = &WanderBehavior{}
+	case "chase":
+		b = &ChaseBehavior{Range: r}
+	case "flee":
+		b = &FleeBehavior{Range: r}
+	case "patrol":
+		if _, ok = l.routes[obj["route"]]; !ok {
+			err = fmt.Errorf("Unknown patrol route %v", obj["route"])
+			return
+		}
+		b = &PatrolBehavior{Waypoints: l.routes[obj["route"]]}
+	default:
+		err = fmt.Errorf("Unknown behavior %v", obj["behavior"])
+	}
+	return
+}
@@ -0,0 +1,82 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Stats shared by every enemy of one kind.
+type EnemyArchetype struct {
+	Rate         float64 // Pixels per second
+	Health       int
+	Sprite       string
+	Padding      int
+	Behavior     string
+	Range        int
+	DropsBombs   bool
+	PassesBricks bool
+	Damage       int
+	Hitbox       *Hitbox
+}
+
+type Archetypes map[string]*EnemyArchetype
+
+func LoadArchetypes(path string) (out Archetypes, err error) {
+	log.Printf("Loading enemy archetypes from %v\n", path)
+	if err = loadJSON(path, &out); err != nil {
+		return
+	}
+	for name, arch := range out {
+		if arch.Hitbox != nil && (arch.Hitbox.W <= 0 || arch.Hitbox.H <= 0) {
+			err = fmt.Errorf("Enemy archetype %v has an empty hitbox", name)
+			return
+		}
+	}
+	return
+}
+
+func loadJSON(path string, v interface{}) (err error) {
+	var (
+		f       *os.File
+		decoder *json.Decoder
+	)
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+	decoder = json.NewDecoder(f)
+	err = decoder.Decode(v)
+	return
+}
+
+// Returns the map object's properties with the archetype's behavior
+// settings filled in wherever the map doesn't override them.
+func (a *EnemyArchetype) getBehaviorProperties(props map[string]string) (out map[string]string) {
+	out = map[string]string{
+		"behavior": a.Behavior,
+	}
+	if a.Range > 0 {
+		out["range"] = strconv.Itoa(a.Range)
+	}
+	for k, v := range props {
+		out[k] = v
+	}
+	return
+}
@@ -0,0 +1,160 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	BATTLE_WINS  = 3 // Rounds needed to win a match
+	BATTLE_BOMBS = 2 // Bombs each player can have out at once
+)
+
+// Maps battles are played on, one per round in turn.  Each needs spawns
+// for as many players as are playing.
+var BATTLE_MAPS = []string{
+	"data/battle01.json",
+}
+
+// Keeps score across the rounds of a match.  Each round is the last player
+// standing, the match goes to whoever wins BATTLE_WINS rounds first.
+type Battle struct {
+	Players int
+	Scores  [MAX_PLAYERS]int
+	Round   int
+	Result  string
+}
+
+func NewBattle(players int) (b *Battle, err error) {
+	if players < 2 || players > MAX_PLAYERS {
+		return nil, fmt.Errorf("Battles need 2 to %v players, not %v", MAX_PLAYERS, players)
+	}
+	b = &Battle{
+		Players: players,
+		Round:   1,
+	}
+	return
+}
+
+// Scores a round won by winner, or drawn if winner is nil.  Starts a new
+// match once someone has won it.
+func (b *Battle) EndRound(winner *Player) {
+	switch {
+	case winner == nil:
+		b.Result = "Draw!"
+	case b.Scores[winner.Index]+1 >= BATTLE_WINS:
+		b.Result = fmt.Sprintf("Player %v wins the match!", winner.Index+1)
+		b.Scores = [MAX_PLAYERS]int{}
+		b.Round = 1
+		return
+	default:
+		b.Result = fmt.Sprintf("Player %v wins round %v", winner.Index+1, b.Round)
+		b.Scores[winner.Index]++
+	}
+	b.Round++
+}
+
+// Text shown before each round.
+func (b *Battle) Describe() (text []string) {
+	var scores []string
+	for i := 0; i < b.Players; i++ {
+		scores = append(scores, fmt.Sprintf("P%v %v", i+1, b.Scores[i]))
+	}
+	if b.Result != "" {
+		text = append(text, b.Result)
+	}
+	return append(text, fmt.Sprintf("Round %v", b.Round)+"\n"+strings.Join(scores, "  "))
+}
+
+// Turns the level into a last-one-standing battle between the first count
+// players.  Spawns for anyone else are left empty.
+func (l *Level) StartBattle(count int) (err error) {
+	if len(l.Players) < count {
+		return fmt.Errorf("Level only has spawns for %v players", len(l.Players))
+	}
+	for _, p := range l.Players[count:] {
+		l.Cast.RemoveActor(p)
+	}
+	l.Players = l.Players[:count]
+	l.Battle = true
+	return
+}
+
+// Ends the round once one player, or nobody, is left.
+func (l *Level) checkBattle() {
+	switch len(l.Players) {
+	case 0:
+		l.startEnding(ENDING_WON)
+	case 1:
+		l.Winner = l.Players[0]
+		l.startEnding(ENDING_WON)
+	}
+}
+
+// Knocks a player out of the round.  Player stays pointing at someone still
+// playing for as long as there is anyone.
+func (l *Level) eliminate(p *Player) {
+	for i, q := range l.Players {
+		if q == p {
+			l.Players = append(l.Players[:i], l.Players[i+1:]...)
+			break
+		}
+	}
+	l.dropBomb(p)
+	l.killActor(p)
+	if l.Player == p && len(l.Players) > 0 {
+		l.Player = l.Players[0]
+	}
+}
+
+// Puts down whatever bomb the player is carrying where they stand, or
+// discards it if there's already one there.
+func (l *Level) dropBomb(p *Player) {
+	var (
+		b = p.Carrying
+		i = l.getActorIndex(p.Actor)
+	)
+	if b == nil {
+		return
+	}
+	for j, loose := range l.loose {
+		if loose == b {
+			l.loose = append(l.loose[:j], l.loose[j+1:]...)
+			break
+		}
+	}
+	b.carrier = nil
+	p.Carrying = nil
+	if l.bombs[i] != nil {
+		l.Cast.RemoveActor(b)
+		return
+	}
+	x, y := l.getPixelFromIndex(i)
+	b.SetX(float64(x))
+	b.SetY(float64(y))
+	l.placeBomb(b, i)
+}
+
+// How many of the player's bombs are out, carried or not.
+func (l *Level) countBombs(p *Player) (n int) {
+	for _, b := range append(l.getBombs(), l.loose...) {
+		if b.owner == p {
+			n++
+		}
+	}
+	return
+}
@@ -0,0 +1,264 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"./system"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// One stage of a boss fight.  A phase starts once the boss's health drops
+// to Health and cycles through Attacks, one every Interval milliseconds.
+type BossPhase struct {
+	Health   int
+	Interval int
+	Attacks  []string
+	Minion   string
+	Bombs    int
+}
+
+type BossArchetype struct {
+	Health     int
+	Sprite     string
+	Size       int
+	Rate       float64 // Pixels per second
+	ChargeRate float64 // Pixels per second
+	Damage     int
+	Phases     []BossPhase
+}
+
+type Bosses map[string]*BossArchetype
+
+func LoadBosses(path string) (out Bosses, err error) {
+	log.Printf("Loading bosses from %v\n", path)
+	if err = loadJSON(path, &out); err != nil {
+		return
+	}
+	for name, arch := range out {
+		if len(arch.Phases) == 0 {
+			err = fmt.Errorf("Boss %v has no phases", name)
+			return
+		}
+	}
+	return
+}
+
+type Boss struct {
+	*Player
+	Size     int
+	Damage   int
+	arch     *BossArchetype
+	phase    int
+	attack   int
+	elapsed  time.Duration
+	charging int
+	width    float64
+	height   float64
+	rng      *rand.Rand
+}
+
+func NewBoss(x float64, y float64, arch *BossArchetype, tw int, th int, rng *rand.Rand) *Boss {
+	return &Boss{
+		Player: &Player{
+			Actor: &Actor{
+				x:      x,
+				y:      y,
+				State:  DOWN | STOPPED,
+				sprite: arch.Sprite,
+				Rate:   arch.Rate,
+				Hitbox: Hitbox{0, 0, float64(arch.Size * tw), float64(arch.Size * th)},
+			},
+			Health: arch.Health,
+			Index:  -1,
+		},
+		Size:   arch.Size,
+		Damage: arch.Damage,
+		arch:   arch,
+		width:  float64(arch.Size * tw),
+		height: float64(arch.Size * th),
+		rng:    rng,
+	}
+}
+
+// Bosses are drawn Size times as large as everyone else.
+func (b *Boss) Scale() int {
+	return b.Size
+}
+
+// Whether the pixel lies inside the boss's footprint.
+func (b *Boss) Contains(x int, y int) bool {
+	var (
+		fx = float64(x)
+		fy = float64(y)
+	)
+	return fx >= b.x && fx < b.x+b.width && fy >= b.y && fy < b.y+b.height
+}
+
+func (b *Boss) Defeated() bool {
+	return b.Health <= 0
+}
+
+func (b *Boss) Update(l *Level, diff time.Duration) {
+	var phase *BossPhase
+	b.updatePhase()
+	phase = &b.arch.Phases[b.phase]
+	if b.charging != 0 {
+		if !b.move(l, b.charging, b.arch.ChargeRate*diff.Seconds()) {
+			b.charging = 0
+			b.SetMovement(STOPPED)
+		}
+		return
+	}
+	b.elapsed += diff
+	if len(phase.Attacks) > 0 && b.elapsed >= time.Duration(phase.Interval)*time.Millisecond {
+		b.elapsed = 0
+		b.doAttack(l, phase, phase.Attacks[b.attack%len(phase.Attacks)])
+		b.attack += 1
+		return
+	}
+	b.approach(l, b.getDistance(diff))
+}
+
+// Moves to the last phase whose health threshold has been reached.
+func (b *Boss) updatePhase() {
+	for i, phase := range b.arch.Phases {
+		if b.Health <= phase.Health && i > b.phase {
+			log.Printf("Boss entering phase %v\n", i)
+			b.phase = i
+			b.attack = 0
+		}
+	}
+}
+
+func (b *Boss) doAttack(l *Level, phase *BossPhase, attack string) {
+	switch attack {
+	case "spawn":
+		b.spawnMinion(l, phase.Minion)
+	case "rain":
+		b.rainBombs(l, phase.Bombs)
+	case "charge":
+		b.charging = b.getPlayerDirection(l)
+	default:
+		log.Printf("Unknown boss attack %v\n", attack)
+	}
+}
+
+// Returns the direction towards the player along the axis they're further
+// away on.
+func (b *Boss) getPlayerDirection(l *Level) int {
+	var (
+		dx = l.Player.X() - (b.x + b.width/2.0 - float64(l.TileWidth)/2.0)
+		dy = l.Player.Y() - (b.y + b.height/2.0 - float64(l.TileHeight)/2.0)
+	)
+	switch {
+	case math.Abs(dx) >= math.Abs(dy) && dx > 0:
+		return RIGHT
+	case math.Abs(dx) >= math.Abs(dy):
+		return LEFT
+	case dy > 0:
+		return DOWN
+	}
+	return UP
+}
+
+func (b *Boss) approach(l *Level, dist float64) {
+	var dir = b.getPlayerDirection(l)
+	if b.move(l, dir, dist) {
+		return
+	}
+	// Blocked, try going around along the other axis.
+	switch dir {
+	case LEFT, RIGHT:
+		if !b.move(l, UP, dist) {
+			b.move(l, DOWN, dist)
+		}
+	default:
+		if !b.move(l, LEFT, dist) {
+			b.move(l, RIGHT, dist)
+		}
+	}
+}
+
+// Moves the whole footprint dist pixels in the given direction.  Returns
+// false if something was in the way.
+func (b *Boss) move(l *Level, dir int, dist float64) bool {
+	var (
+		dx, dy = getDirectionStep(dir)
+		x      = b.x + float64(dx)*dist
+		y      = b.y + float64(dy)*dist
+	)
+	for _, i := range l.getAreaIndices(x, y, b.width, b.height) {
+		if t, err := l.getTile(i); err != nil || !l.tilePassable(b.Actor, t) {
+			return false
+		}
+		if bomb, _ := l.getBomb(i); bomb != nil {
+			return false
+		}
+	}
+	b.SetDirection(dir)
+	b.SetMovement(WALKING)
+	b.x = x
+	b.y = y
+	return true
+}
+
+func (b *Boss) spawnMinion(l *Level, name string) {
+	var (
+		opts []int
+		i    int
+		x    int
+		y    int
+	)
+	for _, i = range l.getAreaIndices(b.x-b.width, b.y-b.height, b.width*3, b.height*3) {
+		if l.TileWalkable(b.Actor, i) && !b.Contains(l.getPixelFromIndex(i)) &&
+			l.getActorIndex(l.Player.Actor) != i {
+			opts = append(opts, i)
+		}
+	}
+	if len(opts) == 0 {
+		return
+	}
+	x, y = l.getPixelFromIndex(opts[b.rng.Intn(len(opts))])
+	if err := l.addEnemy(system.TiledObject{X: x, Y: y}, name); err != nil {
+		log.Printf("Couldn't spawn minion: %v\n", err)
+	}
+}
+
+// Drops bombs on free tiles around the player.
+func (b *Boss) rainBombs(l *Level, count int) {
+	var (
+		p    = l.getActorIndex(l.Player.Actor)
+		px   = l.iToX(p)
+		py   = l.iToY(p)
+		opts []int
+	)
+	for y := py - 2; y <= py+2; y++ {
+		for x := px - 2; x <= px+2; x++ {
+			if l.tileAcceptsBomb(x, y) {
+				opts = append(opts, l.xyToI(x, y))
+			}
+		}
+	}
+	for n := 0; n < count && len(opts) > 0; n++ {
+		var i = b.rng.Intn(len(opts))
+		x, y := l.getPixelFromIndex(opts[i])
+		l.addBombAtPixel(x, y)
+		opts = append(opts[:i], opts[i+1:]...)
+	}
+}
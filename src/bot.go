@@ -0,0 +1,229 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Plays a level through the same actions a person would use.  Walks to the
+// goal once there's a safe way there, otherwise bombs its way to the
+// nearest brick or enemy it can blast and still get clear of.
+type Bot struct {
+	Index int
+	held  int // The direction being held, if any
+}
+
+func NewBot(index int) *Bot {
+	return &Bot{Index: index}
+}
+
+// Returns the bot's actions for the level's next tick.
+func (b *Bot) Next(l *Level) (events []ReplayEvent) {
+	var (
+		p       = l.GetPlayer(b.Index)
+		start   int
+		target  int
+		danger  []time.Duration
+		path    []int
+		bombing bool
+	)
+	if p == nil || l.Locked() {
+		return b.hold(l, 0)
+	}
+	start = l.getActorIndex(p.Actor)
+	danger = l.GetDangerMap()
+	switch {
+	case danger[start] != SAFE:
+		path = l.FindEscape(p.Actor, start, danger)
+	case !b.clear(l, p, danger):
+		// Standing mostly on a safe tile, but not out of the blast yet.
+		path = []int{}
+	default:
+		if path = b.findGoal(l, p, start, danger); path == nil && l.countBombs(p) == 0 {
+			path = b.search(l, p, start, danger, func(i int) bool {
+				return b.worthBombing(l, i) && b.canEscape(l, p, i)
+			})
+			bombing = true
+		}
+	}
+	if path == nil {
+		return b.hold(l, 0)
+	}
+	if target = start; len(path) > 0 {
+		target = path[0]
+	}
+	var dir = b.steer(l, p, target)
+	events = b.hold(l, dir)
+	if bombing && len(path) == 0 && dir == 0 {
+		events = append(events, ReplayEvent{l.Ticks, PlayerAction(b.Index, ACTION_PLACE_BOMB), true})
+	}
+	return
+}
+
+// Returns a safe path to the goal, or nil if there isn't one yet.
+func (b *Bot) findGoal(l *Level, p *Player, start int, danger []time.Duration) []int {
+	if l.Goal == nil {
+		return nil
+	}
+	var goal = l.getActorIndex(l.Goal)
+	return b.search(l, p, start, danger, func(i int) bool {
+		return i == goal
+	})
+}
+
+// Walks only through tiles no blast is headed for and keeps away from
+// enemies.  Returns the path to the nearest tile matching found, which is
+// empty if it's the start, or nil if there isn't one.
+func (b *Bot) search(l *Level, p *Player, start int, danger []time.Duration, found func(int) bool) []int {
+	var (
+		queue = []int{start}
+		prev  = map[int]int{start: start}
+		i     int
+	)
+	for len(queue) > 0 {
+		i, queue = queue[0], queue[1:]
+		if found(i) && i == start {
+			return []int{}
+		} else if found(i) {
+			return getPath(prev, start, i)
+		}
+		for _, j := range l.getNeighbors(i) {
+			if _, seen := prev[j]; seen {
+				continue
+			}
+			if danger[j] != SAFE || !l.TileWalkable(p.Actor, j) || b.threatened(l, j) {
+				continue
+			}
+			prev[j] = i
+			queue = append(queue, j)
+		}
+	}
+	return nil
+}
+
+// Whether an enemy or the boss is on or next to tile i.
+func (b *Bot) threatened(l *Level, i int) bool {
+	var (
+		x, y = l.getPixelFromIndex(i)
+		near = Hitbox{
+			X: float64(x - l.TileWidth),
+			Y: float64(y - l.TileHeight),
+			W: float64(3 * l.TileWidth),
+			H: float64(3 * l.TileHeight),
+		}
+	)
+	for _, j := range append(l.getNeighbors(i), i) {
+		if len(l.enemyIndex.At(j)) > 0 {
+			return true
+		}
+	}
+	return l.Boss != nil && !l.Boss.Defeated() && l.Boss.Bounds().Overlaps(near)
+}
+
+// Whether a bomb at tile i would break a brick or reach an enemy.
+func (b *Bot) worthBombing(l *Level, i int) bool {
+	var (
+		x      = l.iToX(i)
+		y      = l.iToY(i)
+		radius = NewBomb(0, 0).Radius
+	)
+	for _, step := range [][]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+		for n := 1; n <= radius; n++ {
+			var (
+				tx = x + step[0]*n
+				ty = y + step[1]*n
+				j  = l.xyToI(tx, ty)
+			)
+			if tx < 0 || ty < 0 || tx >= l.Map.Width || ty >= l.Map.Height {
+				break
+			}
+			if ttype := TILES[l.tiles[j].Type]; ttype.StopsFire {
+				if ttype.Breakable {
+					return true
+				}
+				break
+			}
+			if len(l.enemyIndex.At(j)) > 0 {
+				return true
+			}
+			if l.Boss != nil && !l.Boss.Defeated() && l.Boss.Contains(l.getPixelFromIndex(j)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Whether the player could bomb tile i and still get away.
+func (b *Bot) canEscape(l *Level, p *Player, i int) bool {
+	var (
+		x, y = l.getPixelFromIndex(i)
+		bomb = NewBomb(float64(x), float64(y))
+	)
+	return len(l.FindEscape(p.Actor, i, l.GetDangerMap(bomb))) > 0
+}
+
+// Whether every tile the player touches is out of harm's way.
+func (b *Bot) clear(l *Level, p *Player, danger []time.Duration) bool {
+	for _, i := range l.getActorIndices(p.Actor) {
+		if danger[i] != SAFE {
+			return false
+		}
+	}
+	return true
+}
+
+// Picks the direction to walk towards tile i, or 0 once the player is
+// wholly on it.  Players only move along the grid, so when the player is
+// off the grid both ways the smaller gap is closed first.
+func (b *Bot) steer(l *Level, p *Player, i int) int {
+	var (
+		x, y    = l.getPixelFromIndex(i)
+		dx      = float64(x) - p.X()
+		dy      = float64(y) - p.Y()
+		pad     = float64(p.Padding)
+		indices = l.getActorIndices(p.Actor)
+	)
+	switch {
+	case len(indices) == 1 && indices[0] == i:
+		return 0
+	case math.Abs(dy) <= pad && math.Abs(dx) >= math.Abs(dy),
+		math.Abs(dx) > pad && math.Abs(dx) < math.Abs(dy):
+		if dx > 0 {
+			return RIGHT
+		}
+		return LEFT
+	case dy > 0:
+		return DOWN
+	}
+	return UP
+}
+
+// Lets go of whatever direction is held and presses dir, if it changed.
+func (b *Bot) hold(l *Level, dir int) (events []ReplayEvent) {
+	if dir == b.held {
+		return
+	}
+	if b.held != 0 {
+		events = append(events, ReplayEvent{l.Ticks, PlayerAction(b.Index, MoveAction(b.held)), false})
+	}
+	if dir != 0 {
+		events = append(events, ReplayEvent{l.Ticks, PlayerAction(b.Index, MoveAction(dir)), true})
+	}
+	b.held = dir
+	return
+}
@@ -0,0 +1,181 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"./system"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const (
+	ACTION_MOVE_UP    = "move_up"
+	ACTION_MOVE_DOWN  = "move_down"
+	ACTION_MOVE_LEFT  = "move_left"
+	ACTION_MOVE_RIGHT = "move_right"
+	ACTION_PLACE_BOMB = "place_bomb"
+	ACTION_THROW_BOMB = "throw_bomb"
+	ACTION_REWIND     = "rewind"
+	ACTION_CONFIRM    = "confirm"
+	ACTION_PAUSE      = "pause"
+	ACTION_CONTROLS   = "controls"
+	ACTION_NETWORK    = "network"
+	ACTION_EXIT       = "exit"
+)
+
+// Actions in the order the controls screen lists them.
+var ACTIONS = []string{
+	ACTION_MOVE_UP,
+	ACTION_MOVE_DOWN,
+	ACTION_MOVE_LEFT,
+	ACTION_MOVE_RIGHT,
+	ACTION_PLACE_BOMB,
+	ACTION_THROW_BOMB,
+	ACTION_REWIND,
+	ACTION_CONFIRM,
+	ACTION_PAUSE,
+}
+
+// Which direction each movement action walks in.
+var MOVE_ACTIONS = map[string]int{
+	ACTION_MOVE_UP:    UP,
+	ACTION_MOVE_DOWN:  DOWN,
+	ACTION_MOVE_LEFT:  LEFT,
+	ACTION_MOVE_RIGHT: RIGHT,
+}
+
+// The movement action for a direction, the inverse of MOVE_ACTIONS.
+func MoveAction(dir int) string {
+	for action, d := range MOVE_ACTIONS {
+		if d == dir {
+			return action
+		}
+	}
+	return ""
+}
+
+// Actions which drive the level.  These are applied on the update loop,
+// between ticks, and are what replays record.
+var LEVEL_ACTIONS = map[string]bool{
+	ACTION_MOVE_UP:    true,
+	ACTION_MOVE_DOWN:  true,
+	ACTION_MOVE_LEFT:  true,
+	ACTION_MOVE_RIGHT: true,
+	ACTION_PLACE_BOMB: true,
+	ACTION_THROW_BOMB: true,
+	ACTION_REWIND:     true,
+}
+
+// Splits off the player an action is for.  Actions for players after the
+// first are prefixed with the player, e.g. "player2_move_up".
+func SplitAction(name string) (index int, action string) {
+	if !strings.HasPrefix(name, "player") {
+		return 0, name
+	}
+	var parts = strings.SplitN(strings.TrimPrefix(name, "player"), "_", 2)
+	if n, err := strconv.Atoi(parts[0]); err == nil && len(parts) == 2 && n >= 1 && n <= MAX_PLAYERS {
+		return n - 1, parts[1]
+	}
+	return 0, name
+}
+
+// The action named for a player, the inverse of SplitAction.
+func PlayerAction(index int, action string) string {
+	if index == 0 {
+		return action
+	}
+	return "player" + strconv.Itoa(index+1) + "_" + action
+}
+
+// Lists every action with its keys.  Choosing one waits for a key press and
+// binds the action to it, the last entry goes back.
+type ControlsMenu struct {
+	*BasicMenu
+	Input    *system.InputMap
+	Font     *system.Font
+	Path     string
+	selected int
+	waiting  bool
+}
+
+func LoadControlsMenu(path string, handler MenuHandler, font *system.Font, input *system.InputMap, controls string) (out *ControlsMenu, err error) {
+	var menu *BasicMenu
+	if menu, err = LoadMenu(path, handler); err != nil {
+		return
+	}
+	out = &ControlsMenu{
+		BasicMenu: menu,
+		Input:     input,
+		Font:      font,
+		Path:      controls,
+	}
+	return
+}
+
+func (m *ControlsMenu) Select(i int) {
+	m.selected = i % (len(ACTIONS) + 1)
+}
+
+func (m *ControlsMenu) SelectNext() {
+	m.Select(m.selected + 1)
+}
+
+func (m *ControlsMenu) SelectPrev() {
+	m.Select(m.selected + len(ACTIONS))
+}
+
+func (m *ControlsMenu) Choose() {
+	if m.selected == len(ACTIONS) {
+		m.Handler(BUTTON_BACK)
+		return
+	}
+	var action = ACTIONS[m.selected]
+	m.waiting = true
+	m.Input.Capture(func(key int, state int) {
+		m.waiting = false
+		if key == system.KeyEsc {
+			return
+		}
+		m.Input.Rebind(action, key)
+		if err := m.Input.Save(m.Path); err != nil {
+			log.Printf("Couldn't save controls: %v\n", err)
+		}
+	})
+}
+
+func (m *ControlsMenu) Draw() {
+	var y = 64.0
+	for i, action := range ACTIONS {
+		var keys []string
+		for _, key := range m.Input.Bindings[action] {
+			keys = append(keys, system.KeyName(key))
+		}
+		var value = strings.Join(keys, " ")
+		if m.waiting && i == m.selected {
+			value = "press a key"
+		}
+		m.Font.Printf(64, y, "%v %-12v %v", m.marker(i), strings.Replace(action, "_", " ", -1), value)
+		y += 40
+	}
+	m.Font.Printf(64, y, "%v back", m.marker(len(ACTIONS)))
+}
+
+func (m *ControlsMenu) marker(i int) string {
+	if i == m.selected {
+		return ">"
+	}
+	return " "
+}
@@ -0,0 +1,125 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Danger value for tiles which no bomb will reach.
+const SAFE time.Duration = math.MaxInt64
+
+// Predicts how long until each tile catches fire, given the bombs on the
+// board plus any extra bombs being considered.  Tiles burning now are 0.
+// Follows the same rules as addFire, including chain reactions and bricks
+// which break and let later blasts through.
+func (l *Level) GetDangerMap(extra ...*Bomb) (danger []time.Duration) {
+	var (
+		bombs = map[int]*Bomb{}
+		fuse  = map[int]time.Duration{}
+		types = make([]int, len(l.tiles))
+		next  int
+	)
+	danger = make([]time.Duration, len(l.tiles))
+	for i, t := range l.tiles {
+		types[i] = t.Type
+		danger[i] = SAFE
+		if l.fire[i] != nil {
+			danger[i] = 0
+		}
+	}
+	for i, b := range l.bombs {
+		if b != nil {
+			bombs[i] = b
+			fuse[i] = b.Remaining()
+		}
+	}
+	for _, b := range extra {
+		i := l.getActorIndex(b.Actor)
+		bombs[i] = b
+		fuse[i] = b.Remaining()
+	}
+	for len(fuse) > 0 {
+		// Detonate the shortest fuse first so its blast can shorten the
+		// fuses of the bombs it reaches.
+		next = -1
+		for i, t := range fuse {
+			if next == -1 || t < fuse[next] || t == fuse[next] && i < next {
+				next = i
+			}
+		}
+		var (
+			t = fuse[next]
+			r = bombs[next].Radius
+			x = l.iToX(next)
+			y = l.iToY(next)
+		)
+		delete(fuse, next)
+		delete(bombs, next)
+		l.markDanger(danger, next, t)
+		for _, step := range [][]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+			for n := 1; n <= r; n++ {
+				var (
+					tx = x + step[0]*n
+					ty = y + step[1]*n
+					j  = l.xyToI(tx, ty)
+				)
+				if tx < 0 || ty < 0 || tx >= l.Map.Width || ty >= l.Map.Height {
+					break
+				}
+				if ttype := TILES[types[j]]; ttype.StopsFire {
+					if ttype.Breakable {
+						l.markDanger(danger, j, t)
+						types[j] = ttype.NextState
+					}
+					break
+				}
+				l.markDanger(danger, j, t)
+				if f, ok := fuse[j]; ok && t < f {
+					fuse[j] = t
+				}
+			}
+		}
+	}
+	return
+}
+
+// Whether an actor placing a bomb where it stands could still get clear
+// of the blast.
+func (l *Level) CanEscapeBomb(a *Actor) bool {
+	var (
+		i      = l.getActorIndex(a)
+		px, py = l.getPixelFromIndex(i)
+		bomb   = NewBomb(float64(px), float64(py))
+		danger []time.Duration
+	)
+	if b, _ := l.getBomb(i); b != nil {
+		return false
+	}
+	danger = l.GetDangerMap(bomb)
+	return len(l.FindEscape(a, i, danger)) > 0
+}
+
+func (l *Level) markDanger(danger []time.Duration, i int, t time.Duration) {
+	if t < danger[i] {
+		danger[i] = t
+	}
+}
+
+// How long it takes an actor to cross one tile.
+func (l *Level) getStepTime(a *Actor) time.Duration {
+	return time.Duration(float64(l.TileWidth) / a.Rate * float64(time.Second))
+}
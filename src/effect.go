@@ -0,0 +1,104 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"./system"
+	"math"
+	"time"
+)
+
+// A burst of particles.  Particles take their frames from the PARTICLE
+// animation of the named sprite.
+type Effect struct {
+	Sprite string
+	Config system.EmitterConfig
+}
+
+const MAX_PARTICLES = 256
+
+var (
+	EFFECT_EXPLOSION = &Effect{
+		Sprite: "spark",
+		Config: system.EmitterConfig{
+			Count:      12,
+			Lifetime:   500 * time.Millisecond,
+			Speed:      90,
+			SpeedRange: 60,
+			Spread:     math.Pi,
+			Scale:      0.3,
+			From:       system.Color{R: 1, G: 0.9, B: 0.4, A: 1},
+			To:         system.Color{R: 0.8, G: 0.2, B: 0, A: 0},
+		},
+	}
+	EFFECT_DEBRIS = &Effect{
+		Sprite: "spark",
+		Config: system.EmitterConfig{
+			Count:      8,
+			Lifetime:   600 * time.Millisecond,
+			Speed:      100,
+			SpeedRange: 60,
+			Angle:      -math.Pi / 2,
+			Spread:     math.Pi / 3,
+			Gravity:    480,
+			Scale:      0.2,
+			From:       system.Color{R: 0.55, G: 0.4, B: 0.3, A: 1},
+			To:         system.Color{R: 0.55, G: 0.4, B: 0.3, A: 0},
+		},
+	}
+	EFFECT_RESPAWN = &Effect{
+		Sprite: "spark",
+		Config: system.EmitterConfig{
+			Count:      16,
+			Lifetime:   400 * time.Millisecond,
+			Speed:      50,
+			SpeedRange: 30,
+			Spread:     math.Pi,
+			Scale:      0.25,
+			From:       system.Color{R: 0.6, G: 0.8, B: 1, A: 1},
+			To:         system.Color{R: 0.2, G: 0.4, B: 1, A: 0},
+		},
+	}
+	EFFECT_PICKUP = &Effect{
+		Sprite: "spark",
+		Config: system.EmitterConfig{
+			Count:      10,
+			Lifetime:   700 * time.Millisecond,
+			Speed:      30,
+			SpeedRange: 40,
+			Angle:      -math.Pi / 2,
+			Spread:     math.Pi,
+			Gravity:    -60,
+			Scale:      0.25,
+			From:       system.Color{R: 1, G: 1, B: 0.8, A: 1},
+			To:         system.Color{R: 1, G: 0.9, B: 0.2, A: 0},
+		},
+	}
+)
+
+// Bursts an effect centered on the tile at index i.
+func (l *Level) emitAtTile(effect *Effect, i int) {
+	var px, py = l.getPixelFromIndex(i)
+	l.emit(effect, float64(px+l.TileWidth/2), float64(py+l.TileHeight/2))
+}
+
+func (l *Level) emit(effect *Effect, x float64, y float64) {
+	var config = effect.Config
+	if sprite, ok := SPRITES[effect.Sprite]; ok {
+		config.TextureRow = sprite.TextureRow
+		config.Anim = sprite.Anims[PARTICLE]
+	}
+	l.Particles.Burst(&config, x, y)
+}
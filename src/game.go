@@ -18,9 +18,16 @@ import (
 	"./system"
 	"fmt"
 	"github.com/banthar/Go-SDL/mixer"
+	"log"
+	"sync"
 	"time"
 )
 
+const CONTROLS_PATH = "data/controls.json"
+
+// How many level actions can wait for the next tick.
+const ACTION_QUEUE = 64
+
 const (
 	UPDATE_HZ int = 60
 	PAINT_HZ  int = 60
@@ -32,32 +39,65 @@ const (
 
 type SoundPlayer func(string)
 
+// Settings from the command line.
+type GameOptions struct {
+	Record  string // Where to save a replay of the current level
+	Replay  string // A replay to play back instead of taking input
+	Seed    int64  // Seeds every level if set, otherwise the time is used
+	Players int    // Plays a battle between this many players if above 1
+	Addr    string // Where to host or join network matches
+	Host    bool   // Hosts a network match straight away
+	Join    bool   // Joins a network match straight away
+	Watch   string // Where to stream the level to spectators, if anywhere
+	Level   string // Plays just this map instead of the usual ones
+}
+
 type Game struct {
 	Controller  *system.Controller
 	SoundSystem *system.Sound
 	Maps        []string
+	Archetypes  Archetypes
+	Bosses      Bosses
 	SoundPaths  map[string]string
 	sounds      map[string]*mixer.Chunk
 	Level       *Level
 	Overlay     *OverlayMenu
 	Billboard   *BillboardMenu
+	Controls    *ControlsMenu
+	Network     *NetworkMenu
+	Net         *Lockstep
+	Spectators  *Spectators
+	Input       *system.InputMap
+	Sources     []system.InputSource
 	Font        *system.Font
 	Menu        Menu
+	lastMenu    Menu
 	menus       map[string]Menu
 	MenuPaths   map[string]string
 	LevelIndex  int
 	Render      bool
 	Camera      *Camera
+	Battle      *Battle
+	Options     GameOptions
+	Replay      *Replay
+	replaying   bool
+	actions     chan ReplayEvent
+	connected   chan connection
+	dropped     chan connection
+	dialing     chan struct{} // Closed to give up connecting
+	offline     *offline
+	ticking     sync.Mutex // Held through each tick and while levels change
 	exit        chan bool
 }
 
-func NewGame(ctrl *system.Controller) (game *Game, err error) {
+func NewGame(ctrl *system.Controller, opts GameOptions) (game *Game, err error) {
 	game = &Game{
 		Controller: ctrl,
 		Maps: []string{
 			"data/level01.json",
 			"data/level02.json",
 			"data/level03.json",
+			"data/level04.json",
 		},
 		SoundPaths: map[string]string{
 			"explosion": "data/explosion.wav",
@@ -67,9 +107,36 @@ func NewGame(ctrl *system.Controller) (game *Game, err error) {
 		},
 		LevelIndex: 0,
 		Render:     false,
+		Options:    opts,
+		actions:    make(chan ReplayEvent, ACTION_QUEUE),
+		connected:  make(chan connection, 1),
+		dropped:    make(chan connection, 1),
 		exit:       make(chan bool, 1),
 	}
+	if opts.Replay != "" {
+		if game.Replay, err = LoadReplay(opts.Replay); err != nil {
+			return
+		}
+		game.Maps = []string{game.Replay.Level}
+		game.Options.Players = game.Replay.Players
+		game.replaying = true
+	} else if opts.Level != "" {
+		game.Maps = []string{opts.Level}
+	} else if opts.Players > 1 {
+		game.Maps = BATTLE_MAPS
+	}
+	if game.Options.Players > 1 {
+		if game.Battle, err = NewBattle(game.Options.Players); err != nil {
+			return
+		}
+	}
 	game.Controller.SetClearColor(BG_R, BG_G, BG_B, BG_A)
+	if game.Input, err = system.LoadInputMap(CONTROLS_PATH); err != nil {
+		return
+	}
+	for pad := 0; pad < system.MaxGamepads; pad++ {
+		game.Sources = append(game.Sources, system.NewGamepad(pad, ctrl))
+	}
 	game.handleKeys()
 	game.handleClose()
 	if game.SoundSystem, err = system.NewSound(); err != nil {
@@ -87,9 +154,29 @@ func NewGame(ctrl *system.Controller) (game *Game, err error) {
 	if game.Billboard, err = LoadBillboardMenu("data/menu_billboard.json", game.handleMenu); err != nil {
 		return
 	}
+	if game.Controls, err = LoadControlsMenu("data/menu_overlay.json", game.handleMenu, game.Font, game.Input, CONTROLS_PATH); err != nil {
+		return
+	}
+	if opts.Watch != "" {
+		if game.Spectators, err = NewSpectators(opts.Watch); err != nil {
+			return
+		}
+	}
+	if game.Network, err = LoadNetworkMenu("data/menu_overlay.json", game.handleMenu, game.Font, opts.Addr); err != nil {
+		return
+	}
 	if err = game.loadSounds(); err != nil {
 		return
 	}
+	if err = LoadSprites("data/animations.json"); err != nil {
+		return
+	}
+	if game.Archetypes, err = LoadArchetypes("data/enemies.json"); err != nil {
+		return
+	}
+	if game.Bosses, err = LoadBosses("data/bosses.json"); err != nil {
+		return
+	}
 	if err = game.setLevel(); err != nil {
 		return
 	}
@@ -97,10 +184,22 @@ func NewGame(ctrl *system.Controller) (game *Game, err error) {
 		return
 	}
 	game.setMenu("splash")
+	switch {
+	case opts.Host:
+		game.hostGame()
+	case opts.Join:
+		game.joinGame()
+	}
 	return
 }
 
 func (g *Game) Terminate() {
+	if g.Net != nil {
+		g.Net.Close()
+	}
+	if g.Spectators != nil {
+		g.Spectators.Close()
+	}
 	g.SoundSystem.Terminate()
 }
 
@@ -115,6 +214,15 @@ func (g *Game) handleMenu(selection int) {
 	switch {
 	case selection == BUTTON_EXIT:
 		g.exit <- true
+	case selection == BUTTON_BACK:
+		if g.Menu == g.Network {
+			g.cancelConnect()
+		}
+		g.Menu = g.lastMenu
+	case selection == BUTTON_HOST:
+		g.hostGame()
+	case selection == BUTTON_JOIN:
+		g.joinGame()
 	case selection == BUTTON_START:
 		if g.Menu == g.Billboard {
 			switch {
@@ -131,88 +239,138 @@ func (g *Game) handleMenu(selection int) {
 	}
 }
 
-func (g *Game) checkKeys() {
-	switch {
-	case g.Controller.Key(system.KeySpace) == 1:
-	case g.Controller.Key(system.KeyEsc) == 1:
-		g.exit <- true
+// Polls gamepads and the like.  Runs on the paint loop, alongside the
+// window's key callbacks, so input is only ever touched from one goroutine.
+func (g *Game) checkInput() {
+	for _, source := range g.Sources {
+		source.Poll(g.Input)
+	}
+	g.checkNetwork()
+	if g.Input.Held(ACTION_EXIT) {
+		select {
+		case g.exit <- true:
+		default:
+		}
 	}
 }
 
 func (g *Game) handleKeys() {
-	g.Controller.SetKeyCallback(func(key int, state int) {
-		if g.Menu == nil {
-			g.handleGameKeys(key, state)
-		} else {
-			g.handleMenuKeys(key, state)
+	// Whether a key works the menus or the level is settled before any of
+	// its actions run, as confirming can close the menu.
+	g.Input.Handler = func(actions []string, pressed bool) {
+		var menu = g.Menu != nil
+		for _, action := range actions {
+			if menu && g.Menu == nil {
+				return
+			} else if menu {
+				g.handleMenuAction(action, pressed)
+			} else {
+				g.handleGameAction(action, pressed)
+			}
 		}
+	}
+	g.Controller.SetKeyCallback(func(key int, state int) {
+		g.Input.KeyEvent(key, state)
 	})
 }
 
-func (g *Game) handleMenuKeys(key int, state int) {
-	switch {
-	case state == 1 && key == system.KeySpace:
-		g.Menu.Choose()
-	case state == 1 && key == system.KeyEnter:
+// Any player can work the menus.
+func (g *Game) handleMenuAction(name string, pressed bool) {
+	var _, action = SplitAction(name)
+	if !pressed {
+		return
+	}
+	switch action {
+	case ACTION_CONFIRM:
 		g.Menu.Choose()
-	case state == 1 && key == system.KeyUp:
-		fallthrough
-	case state == 1 && key == system.KeyLeft:
+	case ACTION_MOVE_UP, ACTION_MOVE_LEFT:
 		g.Menu.SelectPrev()
-	case state == 1 && key == system.KeyDown:
-		fallthrough
-	case state == 1 && key == system.KeyRight:
+	case ACTION_MOVE_DOWN, ACTION_MOVE_RIGHT:
 		g.Menu.SelectNext()
+	case ACTION_CONTROLS:
+		g.showControls()
+	case ACTION_NETWORK:
+		g.showNetwork()
 	}
 }
 
-func (g *Game) handleGameKeys(key int, state int) {
-	switch {
-	case state == 1 && key == system.KeyUp:
-		g.Level.Player.SetDirection(UP)
-		g.Level.Player.SetMovement(WALKING)
-	case state == 1 && key == system.KeyDown:
-		g.Level.Player.SetDirection(DOWN)
-		g.Level.Player.SetMovement(WALKING)
-	case state == 1 && key == system.KeyLeft:
-		g.Level.Player.SetDirection(LEFT)
-		g.Level.Player.SetMovement(WALKING)
-	case state == 1 && key == system.KeyRight:
-		g.Level.Player.SetDirection(RIGHT)
-		g.Level.Player.SetMovement(WALKING)
-	case state == 1 && key == 87: //w
-		//log.Printf("Autowin\n")
-		//g.Level.Won = true
-	case state == 1 && key == system.KeySpace:
-		g.Level.AddBombFromActor(g.Level.Player.Actor)
-	case state == 0:
-		switch {
-		case g.Level.Player.TestState(UP) && key == system.KeyUp ||
-			g.Level.Player.TestState(DOWN) && key == system.KeyDown ||
-			g.Level.Player.TestState(LEFT) && key == system.KeyLeft ||
-			g.Level.Player.TestState(RIGHT) && key == system.KeyRight:
-			g.Level.Player.SetMovement(STOPPED)
+// Level actions are queued for the update loop, which applies them between
+// ticks.  While a replay plays they're ignored.
+func (g *Game) handleGameAction(name string, pressed bool) {
+	var _, action = SplitAction(name)
+	if LEVEL_ACTIONS[action] {
+		if !g.replaying {
+			g.actions <- ReplayEvent{Action: name, Pressed: pressed}
 		}
-	default:
+		return
+	}
+	if !pressed {
+		return
+	}
+	switch action {
+	case ACTION_PAUSE:
+		g.Overlay.SetText([]string{"Paused"})
+		g.Menu = g.Overlay
+	case ACTION_CONTROLS:
+		g.showControls()
+	case ACTION_NETWORK:
+		g.showNetwork()
 	}
 }
 
+// Opens the controls screen, which goes back to whatever was showing.
+func (g *Game) showControls() {
+	if g.Menu == g.Controls {
+		return
+	}
+	g.lastMenu = g.Menu
+	g.Controls.Select(0)
+	g.Menu = g.Controls
+}
+
+// Swaps in the next level.  The update loop is held off meanwhile, so no
+// tick ever runs halfway between two levels.
 func (g *Game) setLevel() (err error) {
+	g.ticking.Lock()
+	defer g.ticking.Unlock()
 	var (
 		index = (g.LevelIndex + len(g.Maps)) % len(g.Maps)
 		path  = g.Maps[index]
 		cast  *Cast
 		desc  []string
+		seed  = g.Options.Seed
 	)
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
 	if cast, err = g.getCast("data/actors.png", 32, 64); err != nil {
 		return
 	}
-	if g.Level, err = LoadLevel(path, cast, func(sound string) {
+	g.saveReplay()
+	if g.Net != nil {
+		g.Net.NextLevel()
+	}
+	if g.replaying {
+		seed = g.Replay.Seed
+		g.Replay.Reset()
+	} else if g.Options.Record != "" {
+		g.Replay = NewReplay(path, seed, g.Options.Players)
+	}
+	if g.Level, err = LoadLevel(path, cast, g.Archetypes, g.Bosses, seed, func(sound string) {
 		g.playSound(sound)
 	}); err != nil {
 		return
 	}
+	if err = g.Level.Map.LoadTextures(); err != nil {
+		return
+	}
 	desc = g.Level.GetDescription()
+	if g.Battle != nil {
+		if err = g.Level.StartBattle(g.Battle.Players); err != nil {
+			return
+		}
+		desc = g.Battle.Describe()
+	}
 	if len(desc) > 0 {
 		g.Overlay.SetText(desc)
 		g.Menu = g.Overlay
@@ -260,23 +418,117 @@ func (g *Game) getCast(path string, width int, height int) (cast *Cast, err erro
 	return LoadCast(path, width, height, 32, 32)
 }
 
-func (g *Game) Run() (err error) {
-	go func() {
+// Saves the replay being recorded, if there is one.
+func (g *Game) saveReplay() {
+	if g.Replay == nil || g.replaying {
+		return
+	}
+	if err := g.Replay.Save(g.Options.Record); err != nil {
+		log.Printf("Couldn't save replay: %v\n", err)
+	}
+}
+
+// Logs what it takes to reproduce a crash, and saves any replay being
+// recorded, before letting the panic carry on.
+func (g *Game) reportCrash() {
+	if r := recover(); r != nil {
+		if g.Level != nil {
+			log.Printf("Crashed on %v with seed %v at tick %v\n", g.Level.Path, g.Level.Random.Seed, g.Level.Ticks)
+		}
+		g.saveReplay()
+		panic(r)
+	}
+}
+
+// Advances the level by one tick, first applying the actions for it.  They
+// come from the replay, the network match, or whatever arrived since the
+// last tick.
+func (g *Game) step(diff time.Duration) {
+	g.ticking.Lock()
+	defer g.ticking.Unlock()
+	var (
+		level  = g.Level
+		events []ReplayEvent
+	)
+	if level.Paused {
+		return
+	}
+	switch {
+	case g.replaying:
+		events = g.Replay.Next(level.Ticks)
+	case g.Net != nil:
 		var (
-			now    time.Time
-			last   time.Time
-			update *time.Ticker
-			diff   time.Duration
+			ready bool
+			err   error
 		)
-		update = time.NewTicker(time.Second / time.Duration(UPDATE_HZ))
-		last = time.Now()
+		if events, ready, err = g.Net.Step(level, g.takeQueued); err != nil {
+			g.dropNetwork(err)
+			return
+		} else if !ready {
+			return
+		}
+	default:
+		events = g.takeQueued()
+	}
+	for _, e := range events {
+		level.ApplyAction(e.Action, e.Pressed)
+		if g.Replay != nil && !g.replaying {
+			g.Replay.Record(level.Ticks, e.Action, e.Pressed)
+		}
+	}
+	level.Update(diff)
+	g.checkReplay(level)
+	if g.Spectators != nil && level.Ticks%SPECTATE_TICKS == 0 {
+		g.spectate(level)
+	}
+}
+
+func (g *Game) spectate(level *Level) {
+	var state = level.SpectatorState()
+	if g.Battle != nil {
+		state.Scores = append([]int{}, g.Battle.Scores[:g.Battle.Players]...)
+	}
+	g.Spectators.Publish(state)
+}
+
+func (g *Game) takeQueued() (events []ReplayEvent) {
+	for {
+		select {
+		case e := <-g.actions:
+			events = append(events, e)
+		default:
+			return
+		}
+	}
+}
+
+// Records checksums, or checks them during playback.
+func (g *Game) checkReplay(level *Level) {
+	if g.Replay == nil {
+		return
+	}
+	if !g.replaying {
+		g.Replay.Checksum(level)
+		return
+	}
+	done, err := g.Replay.Verify(level)
+	if err != nil {
+		log.Printf("%v\n", err)
+	} else if done && !g.Replay.desynced {
+		log.Printf("Replay matched all %v checksums\n", len(g.Replay.Checksums))
+	}
+}
+
+func (g *Game) Run() (err error) {
+	defer g.reportCrash()
+	go func() {
+		defer g.reportCrash()
+		// Every tick is the same length, so replays play out exactly as
+		// they were recorded.  Slow machines fall behind real time instead.
+		var update = time.NewTicker(time.Second / time.Duration(UPDATE_HZ))
 		for true {
 			<-update.C
-			now = time.Now()
-			diff = now.Sub(last)
-			g.checkKeys()
-			g.Level.Update(diff)
-			last = now
+			g.step(time.Second / time.Duration(UPDATE_HZ))
 		}
 	}()
 	running := true
@@ -287,6 +539,9 @@ func (g *Game) Run() (err error) {
 		BeginPaint()
 		PaintMap(g.Controller, g.Level.Map)
 		PaintCast(g.Controller, g.Level.Cast)
+		PaintParticles(g.Controller, g.Level.Cast, g.Level.Particles)
+		PaintLight(g.Controller, g.Level)
+		PaintHud(g.Controller, g.Font, g.Level)
 		if g.Menu != nil {
 			PaintMenu(g.Controller, g.Menu)
 			g.Level.Paused = true
@@ -294,18 +549,25 @@ func (g *Game) Run() (err error) {
 			g.Level.Paused = false
 		}
 		EndPaint()
+		g.checkInput()
 		select {
 		case <-g.exit:
 			paint.Stop()
+			g.saveReplay()
 			running = false
 		default:
 		}
 		if g.Level.Died {
 			g.Level.Died = false
-			time.AfterFunc(time.Duration(200)*time.Millisecond, func() {
-				g.Menu = g.Billboard
-				g.Billboard.SetFrame(BILLBOARD_DIED)
-			})
+			g.Menu = g.Billboard
+			g.Billboard.SetFrame(BILLBOARD_DIED)
+		} else if g.Level.Won && g.Battle != nil {
+			// The finished level still ticks, and spectate reads the scores.
+			g.ticking.Lock()
+			g.Battle.EndRound(g.Level.Winner)
+			g.LevelIndex += 1
+			g.ticking.Unlock()
+			g.setLevel()
 		} else if g.Level.Won {
 			if g.LevelIndex == len(g.Maps)-1 {
 				g.Menu = g.Billboard
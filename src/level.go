@@ -18,6 +18,7 @@ import (
 	"./system"
 	"fmt"
 	"log"
+	"math"
 	"strings"
 	"time"
 )
@@ -28,26 +29,54 @@ type Level struct {
 	Camera     *Camera
 	Cast       *Cast
 	Player     *Player
+	Players    []*Player
+	Winner     *Player
 	Goal       *Actor
+	Boss       *Boss
+	Particles  *system.ParticleSystem
 	tiles      []Tile
 	bombs      []*Bomb
 	fire       []*Fire
+	pickups    []*Pickup
+	loose      []*Bomb
 	enemies    []*Enemy
+	enemyIndex *EnemyIndex
+	archetypes Archetypes
+	bosses     Bosses
+	routes     map[string][]int
+	danger     []time.Duration
+	dying      []Mortal
+	ending     int
+	torches    []Light
+	seen       []bool
+	Light      []float64
+	history    *History
+	loop       time.Duration
+	loopTimer  time.Duration
+	flash      time.Duration
+	spawnX     float64
+	spawnY     float64
+	Path       string
+	Random     *system.Random
+	Ticks      int
 	TileWidth  int
 	TileHeight int
 	Won        bool
 	Died       bool
 	Paused     bool
+	Dark       bool
+	Battle     bool
 }
 
-func LoadLevel(path string, cast *Cast, snd SoundPlayer) (out *Level, err error) {
+func LoadLevel(path string, cast *Cast, archetypes Archetypes, bosses Bosses, seed int64, snd SoundPlayer) (out *Level, err error) {
 	var (
-		tm    *system.TiledMap
-		cw    float64
-		ch    float64
-		count int
+		tm     *system.TiledMap
+		cw     float64
+		ch     float64
+		count  int
+		random = system.NewRandom(seed)
 	)
-	log.Printf("Loading level from %v\n", path)
+	log.Printf("Loading level from %v with seed %v\n", path, seed)
 	if tm, err = system.LoadMap(path); err != nil {
 		return
 	}
@@ -58,48 +87,87 @@ func LoadLevel(path string, cast *Cast, snd SoundPlayer) (out *Level, err error)
 		Map:        tm,
 		Cast:       cast,
 		Camera:     NewCamera(0, 0, cw, ch),
+		Particles:  system.NewParticleSystem(MAX_PARTICLES, random.Stream("particles")),
 		TileWidth:  tm.Tilewidth,
 		TileHeight: tm.Tileheight,
+		Path:       path,
+		Random:     random,
 		Paused:     false,
 		Won:        false,
 		Died:       false,
+		Dark:       tm.Properties["dark"] == "true",
 		tiles:      make([]Tile, count),
 		bombs:      make([]*Bomb, count),
 		fire:       make([]*Fire, count),
+		seen:       make([]bool, count),
+		Light:      make([]float64, count),
+		history:    NewHistory(),
+		pickups:    make([]*Pickup, count),
+		loose:      make([]*Bomb, 0),
 		enemies:    make([]*Enemy, 0),
+		enemyIndex: NewEnemyIndex(count),
+		routes:     map[string][]int{},
+		archetypes: archetypes,
+		bosses:     bosses,
 		snd:        snd,
 	}
+	if out.loop, err = parseLoop(tm.Properties); err != nil {
+		return
+	}
 	if err = out.parseTiles(); err != nil {
 		return
 	}
 	if err = out.parseObjects(); err != nil {
 		return
 	}
+	out.updateLight()
 	return
 }
 
+// Runs one tick.  Callers check Paused, so a tick is never skipped once
+// its actions have been applied.
 func (l *Level) Update(diff time.Duration) (err error) {
-	if l.Paused {
-		return
-	}
 	var (
 		layer *system.TiledLayer
 	)
+	l.Ticks++
 	if layer, err = l.Map.GetLayer("tilelayer", "Tiles"); err != nil {
 		return
 	}
 	for _, t := range TILES {
-		t.Anim.Next()
+		if t.Anim != nil {
+			t.Anim.Update(diff)
+		}
 	}
 	for i, t := range l.tiles {
-		layer.Data[i] = TILES[t.Type].Anim.Curr()
+		if anim := TILES[t.Type].Anim; anim != nil {
+			layer.Data[i] = anim.Curr()
+		}
 	}
-	for _, b := range l.bombs {
-		if b != nil {
-			b.AddTime(diff)
-			b.Update(l)
+	l.Particles.Update(diff)
+	l.updateLight()
+	if l.history.Rewinding {
+		if !l.history.Rewind(l, diff) {
+			l.StopRewind()
+		}
+		l.Cast.Update(l, diff)
+		return
+	}
+	if l.ending != ENDING_NONE {
+		l.Cast.Update(l, diff)
+		l.updateDying()
+		l.updateEnding()
+		return
+	}
+	l.history.Record(l, diff)
+	l.updateLoop(diff)
+	for _, b := range l.getBombs() {
+		b.AddTime(diff)
+		if b.Update(l) {
+			l.slideBomb(b, diff)
 		}
 	}
+	l.updateLooseBombs(diff)
 	for i, f := range l.fire {
 		if f != nil {
 			f.AddTime(diff)
@@ -107,48 +175,530 @@ func (l *Level) Update(diff time.Duration) (err error) {
 			l.setFireDirection(i)
 		}
 	}
+	l.danger = l.GetDangerMap()
+	var burning = l.getEnemiesInFire()
 	for i := len(l.enemies) - 1; i >= 0; i-- {
 		e := l.enemies[i]
-		if l.checkActorBurned(e.Player.Actor) {
-			l.Cast.RemoveActor(e)
+		e.AddTime(diff)
+		if burning[e] && l.checkActorBurned(e.Player.Actor) && e.Hurt(1) {
+			l.killActor(e)
+			l.enemyIndex.Remove(e)
 			l.enemies = append(l.enemies[:i], l.enemies[i+1:]...)
 		} else {
-			e.Update(l)
+			e.Update(l, diff)
+			l.enemyIndex.Update(e, l.getActorIndices(e.Player.Actor))
 		}
 	}
 	l.Cast.Update(l, diff)
-	l.Player.Update(l)
-	if l.checkActorBurned(l.Player.Actor) {
-		l.Died = true
+	l.updateDying()
+	for _, p := range append([]*Player{}, l.Players...) {
+		l.updatePlayer(p, diff)
+	}
+	if l.Battle {
+		l.checkBattle()
+	}
+	if l.Boss != nil && !l.Boss.Defeated() {
+		l.updateBoss(diff)
+		return
+	}
+	if l.Battle {
+		return
+	}
+	if l.Goal == nil && l.Boss != nil || l.Goal != nil && l.Player.Overlaps(l.Goal) {
+		l.Winner = l.Player
+		l.startEnding(ENDING_WON)
+	}
+	return
+}
+
+func (l *Level) updatePlayer(p *Player, diff time.Duration) {
+	p.AddTime(diff)
+	p.Update(l, diff)
+	l.checkPickup(p)
+	if l.checkActorBurned(p.Actor) && p.Hurt(1) {
+		l.killPlayer(p)
+		return
+	}
+	for _, i := range l.getActorIndices(p.Actor) {
+		for _, e := range l.enemyIndex.At(i) {
+			if p.Overlaps(e.Player.Actor) && p.Hurt(e.Damage) {
+				l.killPlayer(p)
+				return
+			}
+		}
+	}
+}
+
+// Returns the player with the given index, or nil if they aren't playing.
+func (l *Level) GetPlayer(index int) *Player {
+	for _, p := range l.Players {
+		if p.Index == index {
+			return p
+		}
+	}
+	return nil
+}
+
+// Applies a level action, pressed or released, to the player it names.
+// Rewinding lasts for as long as the action is held.
+func (l *Level) ApplyAction(name string, pressed bool) {
+	var (
+		index, action = SplitAction(name)
+		player        = l.GetPlayer(index)
+	)
+	if player == nil {
+		return
+	}
+	if action == ACTION_REWIND {
+		if pressed {
+			l.StartRewind()
+		} else {
+			l.StopRewind()
+		}
+		return
+	}
+	if dir, ok := MOVE_ACTIONS[action]; ok {
+		switch {
+		case l.Locked():
+			player.HoldDirection(dir, pressed)
+		case pressed:
+			player.PressDirection(l, dir)
+		default:
+			player.ReleaseDirection(l, dir)
+		}
+		return
+	}
+	if l.Locked() {
+		return
 	}
-	if l.Cast.Overlaps(l.Player.Actor, l.Goal) {
+	if !pressed {
+		return
+	}
+	switch action {
+	case ACTION_PLACE_BOMB:
+		l.AddBombFromPlayer(player)
+	case ACTION_THROW_BOMB:
+		l.LiftOrThrowBomb(player)
+	}
+}
+
+// Plays the last REWIND_WINDOW of the level backwards until StopRewind is
+// called or the recording runs out.  Works while the player is dying too,
+// which undoes the death.
+func (l *Level) StartRewind() {
+	if l.Battle {
+		return
+	}
+	if l.ending == ENDING_NONE || l.ending == ENDING_DIED {
+		l.history.Start()
+	}
+}
+
+// Resumes play from wherever the rewind got to, with players walking
+// whichever way is held now.
+func (l *Level) StopRewind() {
+	if !l.history.Rewinding {
+		return
+	}
+	l.history.Stop()
+	for _, p := range l.Players {
+		p.FollowHeld(l)
+	}
+}
+
+// Whether a death or victory sequence or a rewind is playing.  Input is
+// ignored until it's over, other than keeping track of held directions.
+func (l *Level) Locked() bool {
+	return l.ending != ENDING_NONE || l.history.Rewinding
+}
+
+// Ends the level, or in a battle knocks the player out of the round.
+func (l *Level) killPlayer(p *Player) {
+	if l.Battle {
+		l.eliminate(p)
+		return
+	}
+	l.startEnding(ENDING_DIED)
+}
+
+// Freezes the level while the player's death or victory animation plays.
+// Died or Won is set once it finishes.
+func (l *Level) startEnding(ending int) {
+	if l.ending != ENDING_NONE {
+		return
+	}
+	l.ending = ending
+	switch ending {
+	case ENDING_DIED:
+		l.Player.Die()
+	case ENDING_WON:
+		if l.Winner != nil {
+			l.Winner.State = VICTORY
+		}
+	}
+}
+
+func (l *Level) updateEnding() {
+	if l.ending == ENDING_DONE || !l.endingFinished() {
+		return
+	}
+	switch l.ending {
+	case ENDING_DIED:
+		l.Died = true
+	case ENDING_WON:
 		l.Won = true
 	}
+	l.ending = ENDING_DONE
+}
+
+// Whether the ending's animations have played out.  Battles wait for the
+// winner, if there is one, and for everyone knocked out at the end.
+func (l *Level) endingFinished() bool {
+	if l.Battle {
+		return len(l.dying) == 0 && (l.Winner == nil || l.Winner.Finished())
+	}
+	return l.Player.Finished()
+}
+
+// Plays an actor's death animation and takes it out of the cast afterwards.
+// Callers stop updating the actor themselves.
+func (l *Level) killActor(m Mortal) {
+	m.Die()
+	l.dying = append(l.dying, m)
+}
+
+func (l *Level) updateDying() {
+	for i := len(l.dying) - 1; i >= 0; i-- {
+		if l.dying[i].Finished() {
+			l.Cast.RemoveActor(l.dying[i])
+			l.dying = append(l.dying[:i], l.dying[i+1:]...)
+		}
+	}
+}
+
+// Runs the boss fight.  The goal stays hidden until the boss is beaten and
+// levels with a boss but no goal are won by beating it.
+func (l *Level) updateBoss(diff time.Duration) {
+	var b = l.Boss
+	b.AddTime(diff)
+	if l.checkActorBurned(b.Actor) && b.Hurt(1) {
+		log.Printf("Boss defeated\n")
+		l.killActor(b)
+		if l.Goal != nil {
+			l.Cast.AddActor(l.Goal)
+		}
+		return
+	}
+	b.Update(l, diff)
+	for _, p := range append([]*Player{}, l.Players...) {
+		if p.Overlaps(b.Actor) && p.Hurt(b.Damage) {
+			l.killPlayer(p)
+		}
+	}
+}
+
+// Returns the indices of every tile touched by the given pixel rectangle.
+func (l *Level) getAreaIndices(x float64, y float64, w float64, h float64) (out []int) {
+	var (
+		minx = int(math.Floor(x / float64(l.TileWidth)))
+		miny = int(math.Floor(y / float64(l.TileHeight)))
+		maxx = int(math.Ceil((x+w)/float64(l.TileWidth))) - 1
+		maxy = int(math.Ceil((y+h)/float64(l.TileHeight))) - 1
+	)
+	for ty := miny; ty <= maxy; ty++ {
+		for tx := minx; tx <= maxx; tx++ {
+			if tx >= 0 && ty >= 0 && tx < l.Map.Width && ty < l.Map.Height {
+				out = append(out, l.xyToI(tx, ty))
+			}
+		}
+	}
 	return
 }
 
-func (l *Level) AddBombFromActor(a *Actor) {
+// Places a bomb owned by the player where they stand.  In a battle each
+// player only has BATTLE_BOMBS to use at once.
+func (l *Level) AddBombFromPlayer(p *Player) {
 	var (
-		x   = int(a.X() + float64(l.TileWidth)/2.0)
-		y   = int(a.Y() + float64(l.TileHeight)/2.0)
+		x   = int(p.X() + float64(l.TileWidth)/2.0)
+		y   = int(p.Y() + float64(l.TileHeight)/2.0)
 		err error
 		b   *Bomb
 	)
+	if l.Battle && l.countBombs(p) >= BATTLE_BOMBS {
+		return
+	}
 	if b, err = l.addBombAtPixel(x, y); err != nil {
 		return
 	}
-	a.Bomb = b
+	if b.owner == nil {
+		b.owner = p
+	}
+	p.Bomb = b
 }
 
-func (l *Level) checkActorBurned(a *Actor) bool {
+// Starts a bomb sliding in the given direction until it hits something.
+func (l *Level) KickBomb(b *Bomb, dir int) {
+	if b.carrier != nil || b.Flying {
+		return
+	}
+	b.Sliding = dir
+}
+
+// Picks up the bomb under or in front of the player, or throws the bomb
+// the player is already carrying.
+func (l *Level) LiftOrThrowBomb(p *Player) {
+	if !p.HasPower(POWER_THROW) {
+		return
+	}
+	if p.Carrying != nil {
+		l.throwBomb(p)
+		return
+	}
 	var (
-		i int
+		i = l.getActorIndex(p.Actor)
+		b *Bomb
 	)
-	i = l.getActorIndex(a)
+	if b, _ = l.getBomb(i); b == nil {
+		b = l.getBombAhead(p.Actor)
+	}
+	if b == nil || b.Sliding != 0 {
+		return
+	}
+	l.bombs[l.getActorIndex(b.Actor)] = nil
+	if p.Bomb == b {
+		p.Bomb = nil
+	}
+	b.carrier = p
+	p.Carrying = b
+	l.loose = append(l.loose, b)
+}
+
+func (l *Level) throwBomb(p *Player) {
+	var (
+		b      = p.Carrying
+		dir    = p.State & (LEFT | RIGHT | UP | DOWN)
+		i      = l.getActorIndex(p.Actor)
+		dx, dy = getDirectionStep(dir)
+		x, y   int
+		ok     bool
+	)
+	if x, y, ok = l.findLanding(l.iToX(i), l.iToY(i), dx, dy, BOMB_THROW_DISTANCE); !ok {
+		return
+	}
+	px, py := l.getPixelFromIndex(i)
+	b.SetX(float64(px))
+	b.SetY(float64(py))
+	px, py = l.getPixelFromIndex(l.xyToI(x, y))
+	b.tX = float64(px)
+	b.tY = float64(py)
+	b.heading = dir
+	b.Flying = true
+	b.carrier = nil
+	p.Carrying = nil
+}
+
+// Returns the first tile at least dist tiles away in the given direction
+// which can take a bomb, wrapping around the edges of the level.
+func (l *Level) findLanding(x int, y int, dx int, dy int, dist int) (tx int, ty int, ok bool) {
+	var (
+		w = l.Map.Width
+		h = l.Map.Height
+	)
+	if dx == 0 && dy == 0 {
+		return
+	}
+	for i := dist; i < dist+w+h; i++ {
+		tx = ((x+dx*i)%w + w) % w
+		ty = ((y+dy*i)%h + h) % h
+		if l.tileAcceptsBomb(tx, ty) {
+			ok = true
+			return
+		}
+	}
+	return
+}
+
+func (l *Level) updateLooseBombs(diff time.Duration) {
+	for i := len(l.loose) - 1; i >= 0; i-- {
+		b := l.loose[i]
+		switch {
+		case b.carrier != nil:
+			// The fuse doesn't burn while a bomb is being carried.
+			b.SetX(b.carrier.X())
+			b.SetY(b.carrier.Y() - float64(l.TileHeight)/2.0)
+		case b.Flying:
+			b.AddTime(diff)
+			if !b.Fly(l, diff) {
+				continue
+			}
+			var (
+				j      = l.getPixelIndex(int(b.tX), int(b.tY))
+				dx, dy = getDirectionStep(b.heading)
+			)
+			if l.bombs[j] != nil {
+				// Something landed here first, bounce to the next tile.
+				if x, y, ok := l.findLanding(l.iToX(j), l.iToY(j), dx, dy, 1); ok {
+					px, py := l.getPixelFromIndex(l.xyToI(x, y))
+					b.tX = float64(px)
+					b.tY = float64(py)
+				}
+				continue
+			}
+			b.Flying = false
+			l.loose = append(l.loose[:i], l.loose[i+1:]...)
+			l.placeBomb(b, j)
+		}
+	}
+}
+
+func (l *Level) slideBomb(b *Bomb, diff time.Duration) {
+	if b.Sliding == 0 {
+		return
+	}
+	var (
+		i      = l.getActorIndex(b.Actor)
+		dx, dy = getDirectionStep(b.Sliding)
+		px, py = l.getPixelFromIndex(i)
+		step   = BOMB_SLIDE_RATE * diff.Seconds()
+		j      int
+	)
+	if b.X() == float64(px) && b.Y() == float64(py) &&
+		!l.tileAcceptsBomb(l.iToX(i)+dx, l.iToY(i)+dy) {
+		b.Sliding = 0
+		return
+	}
+	b.SetX(stepToGrid(b.X(), dx, l.TileWidth, step))
+	b.SetY(stepToGrid(b.Y(), dy, l.TileHeight, step))
+	if j = l.getActorIndex(b.Actor); j == i {
+		return
+	}
+	// Something may have been put on the next tile since the slide
+	// checked, so it stops short rather than land on it.
+	if !l.tileAcceptsBomb(l.iToX(j), l.iToY(j)) {
+		b.SetX(float64(px))
+		b.SetY(float64(py))
+		b.Sliding = 0
+		return
+	}
+	l.bombs[i] = nil
+	l.placeBomb(b, j)
+}
+
+// Puts a bomb into the grid at index i, setting it off if it lands in fire.
+func (l *Level) placeBomb(b *Bomb, i int) {
+	l.bombs[i] = b
 	if l.fire[i] != nil {
-		return true
+		b.AddTime(time.Duration(1000) * time.Hour)
+	}
+}
+
+func (l *Level) tileAcceptsBomb(x int, y int) bool {
+	if x < 0 || y < 0 || x >= l.Map.Width || y >= l.Map.Height {
+		return false
+	}
+	var i = l.xyToI(x, y)
+	if l.bombs[i] != nil || !TILES[l.tiles[i].Type].Passable {
+		return false
+	}
+	for _, p := range l.Players {
+		if l.getActorIndex(p.Actor) == i {
+			return false
+		}
+	}
+	return len(l.enemyIndex.At(i)) == 0
+}
+
+// Returns the bomb directly in front of an actor, if any.
+func (l *Level) getBombAhead(a *Actor) (b *Bomb) {
+	var (
+		x = int(a.X() + float64(l.TileWidth)/2.0)
+		y = int(a.Y() + float64(l.TileHeight)/2.0)
+	)
+	switch {
+	case a.TestState(UP):
+		y -= l.TileHeight/2 + 1
+	case a.TestState(DOWN):
+		y += l.TileHeight/2 + 1
+	case a.TestState(LEFT):
+		x -= l.TileWidth/2 + 1
+	case a.TestState(RIGHT):
+		x += l.TileWidth/2 + 1
+	}
+	b, _ = l.getBombAtPixel(x, y)
+	return
+}
+
+func (l *Level) getBombs() (out []*Bomb) {
+	for _, b := range l.bombs {
+		if b != nil {
+			out = append(out, b)
+		}
+	}
+	return
+}
+
+func (l *Level) checkPickup(p *Player) {
+	for _, i := range l.getActorIndices(p.Actor) {
+		var pickup = l.pickups[i]
+		if pickup == nil || !p.Overlaps(pickup.Actor) {
+			continue
+		}
+		p.Powers |= pickup.Power
+		l.emitAtTile(EFFECT_PICKUP, i)
+		l.pickups[i] = nil
+		l.Cast.RemoveActor(pickup)
+	}
+}
+
+// Returns the enemies touching any burning tile.
+func (l *Level) getEnemiesInFire() (out map[*Enemy]bool) {
+	out = map[*Enemy]bool{}
+	for i, f := range l.fire {
+		if f == nil {
+			continue
+		}
+		for _, e := range l.enemyIndex.At(i) {
+			out[e] = true
+		}
+	}
+	return
+}
+
+// Actors only get burned once enough of their hitbox is in the fire, so
+// clipping the edge of a blast while running past is survivable.
+const BURN_OVERLAP = 0.25
+
+func (l *Level) checkActorBurned(a *Actor) bool {
+	var (
+		bounds = a.Bounds()
+		burnt  float64
+	)
+	if bounds.W <= 0 || bounds.H <= 0 {
+		// Nothing to measure, go by the tile the actor stands on.
+		return l.fire[l.getActorIndex(a)] != nil
+	}
+	for _, i := range l.getActorIndices(a) {
+		if l.fire[i] != nil {
+			burnt += bounds.Intersect(l.getTileBounds(i))
+		}
+	}
+	return burnt >= bounds.Area()*BURN_OVERLAP
+}
+
+// Returns the indices of every tile the actor's hitbox touches.
+func (l *Level) getActorIndices(a *Actor) []int {
+	var b = a.Bounds()
+	return l.getAreaIndices(b.X, b.Y, b.W, b.H)
+}
+
+func (l *Level) getTileBounds(i int) Hitbox {
+	var x, y = l.getPixelFromIndex(i)
+	return Hitbox{
+		X: float64(x),
+		Y: float64(y),
+		W: float64(l.TileWidth),
+		H: float64(l.TileHeight),
 	}
-	return false
 }
 
 func (l *Level) getActorIndex(a *Actor) (i int) {
@@ -184,28 +734,34 @@ func (l *Level) GetMovementOptions(a *Actor) (out []*Tile) {
 	x = l.iToX(i)
 	y = l.iToY(i)
 	if t, err = l.getTile(l.xyToI(x+1, y)); err == nil {
-		if TILES[t.Type].Passable {
+		if l.tilePassable(a, t) {
 			out = append(out, t)
 		}
 	}
 	if t, err = l.getTile(l.xyToI(x-1, y)); err == nil {
-		if TILES[t.Type].Passable {
+		if l.tilePassable(a, t) {
 			out = append(out, t)
 		}
 	}
 	if t, err = l.getTile(l.xyToI(x, y+1)); err == nil {
-		if TILES[t.Type].Passable {
+		if l.tilePassable(a, t) {
 			out = append(out, t)
 		}
 	}
 	if t, err = l.getTile(l.xyToI(x, y-1)); err == nil {
-		if TILES[t.Type].Passable {
+		if l.tilePassable(a, t) {
 			out = append(out, t)
 		}
 	}
 	return
 }
 
+// Whether the tile's type lets the actor through, ignoring what's on it.
+func (l *Level) tilePassable(a *Actor, t *Tile) bool {
+	var ttype = TILES[t.Type]
+	return ttype.Passable || a.PassesBricks && ttype.Breakable
+}
+
 func (l *Level) TestPixelPassable(a *Actor, x int, y int) bool {
 	var (
 		t   *Tile
@@ -215,20 +771,18 @@ func (l *Level) TestPixelPassable(a *Actor, x int, y int) bool {
 		return false
 	} else if b, _ := l.getBombAtPixel(x, y); b != nil {
 		return b == a.Bomb
+	} else if l.Boss != nil && !l.Boss.Defeated() && a != l.Boss.Actor && l.Boss.Contains(x, y) {
+		return false
 	} else {
-		for _, e := range l.enemies {
-			if e.Player.Actor == a {
-				continue
-			}
-			i := l.getActorIndex(e.Player.Actor)
-			if &l.tiles[i] == t {
+		for _, e := range l.enemyIndex.At(l.getPixelIndex(x, y)) {
+			if e.Player.Actor != a {
 				// Enemies are not passable
 				return false
 			}
 		}
 	}
 	a.Bomb = nil
-	return TILES[t.Type].Passable
+	return l.tilePassable(a, t)
 }
 
 func (l *Level) Explode(b *Bomb) {
@@ -245,6 +799,7 @@ func (l *Level) Explode(b *Bomb) {
 		l.bombs[i] = nil
 		l.Cast.RemoveActor(b)
 		l.snd("explosion")
+		l.emitAtTile(EFFECT_EXPLOSION, i)
 		if l.addFire(x, y) {
 			l.addFireColumn(x, y, b.Radius, 1, 0)
 			l.addFireColumn(x, y, b.Radius, -1, 0)
@@ -356,6 +911,7 @@ func (l *Level) addFire(x int, y int) bool {
 		continues = false
 		if ttype.Breakable {
 			t.Type = ttype.NextState
+			l.emitAtTile(EFFECT_DEBRIS, i)
 		} else {
 			return continues
 		}
@@ -368,6 +924,33 @@ func (l *Level) addFire(x int, y int) bool {
 	return continues
 }
 
+// Moves v by step in direction d, stopping at the next multiple of size so
+// that sliding objects can't skip past a tile boundary.
+func stepToGrid(v float64, d int, size int, step float64) float64 {
+	var s = float64(size)
+	switch {
+	case d > 0:
+		return math.Min(v+step, (math.Floor(v/s)+1)*s)
+	case d < 0:
+		return math.Max(v-step, (math.Ceil(v/s)-1)*s)
+	}
+	return v
+}
+
+func getDirectionStep(dir int) (dx int, dy int) {
+	switch {
+	case dir&UP == UP:
+		dy = -1
+	case dir&DOWN == DOWN:
+		dy = 1
+	case dir&LEFT == LEFT:
+		dx = -1
+	case dir&RIGHT == RIGHT:
+		dx = 1
+	}
+	return
+}
+
 func (l *Level) getPixelIndex(x int, y int) (i int) {
 	x = x / l.Map.Tilewidth
 	y = y / l.Map.Tileheight
@@ -460,26 +1043,121 @@ func (l *Level) parseObjects() (err error) {
 	if layer, err = l.Map.GetLayer("objectgroup", "Objects"); err != nil {
 		return
 	}
+	// Routes need to exist before the enemies patrolling them.
+	for _, obj := range layer.Objects {
+		if obj.Type == "waypoint" {
+			i := l.getPixelIndex(obj.X, obj.Y)
+			l.routes[obj.Name] = append(l.routes[obj.Name], i)
+		}
+	}
 	for _, obj := range layer.Objects {
 		switch obj.Type {
-		case "player":
-			l.Player = NewPlayer(float64(obj.X), float64(obj.Y), DOWN|STOPPED, 0)
-			l.Cast.AddActor(l.Player)
+		case "player", "player1", "player2", "player3", "player4":
+			err = l.addPlayer(obj)
 		case "enemy":
-			enemy := NewEnemy(float64(obj.X), float64(obj.Y), DOWN|STOPPED)
-			l.enemies = append(l.enemies, enemy)
-			l.Cast.AddActor(enemy)
+			name := obj.Properties["archetype"]
+			if name == "" {
+				name = "enemy"
+			}
+			err = l.addEnemy(obj, name)
 		case "goal":
-			l.Goal = NewActor(float64(obj.X), float64(obj.Y), GOAL, 1)
+			l.Goal = NewActor(float64(obj.X), float64(obj.Y), GOAL, "goal")
 			l.Cast.AddActor(l.Goal)
+		case "boss":
+			name := obj.Properties["boss"]
+			if name == "" {
+				name = "boss"
+			}
+			arch, ok := l.bosses[name]
+			if !ok {
+				err = fmt.Errorf("Unknown boss %v", name)
+				break
+			}
+			l.Boss = NewBoss(float64(obj.X), float64(obj.Y), arch, l.TileWidth, l.TileHeight, l.Random.Stream("boss"))
+			l.Cast.AddActor(l.Boss)
+		case "torch":
+			var light Light
+			if light, err = l.parseTorch(obj.X, obj.Y, obj.Properties); err == nil {
+				l.torches = append(l.torches, light)
+			}
+		case "powerup":
+			power, ok := POWERS[obj.Properties["power"]]
+			if !ok {
+				err = fmt.Errorf("Unknown power %v", obj.Properties["power"])
+				break
+			}
+			pickup := NewPickup(float64(obj.X), float64(obj.Y), power)
+			l.pickups[l.getActorIndex(pickup.Actor)] = pickup
+			l.Cast.AddActor(pickup)
+		default:
+			if _, ok := l.archetypes[obj.Type]; ok {
+				err = l.addEnemy(obj, obj.Type)
+			}
 		}
 		if err != nil {
 			return
 		}
 	}
+	if l.Boss != nil && l.Goal != nil {
+		l.Cast.RemoveActor(l.Goal)
+	}
 	return
 }
 
+// Spawns are "player" or "player1" to "player4", "player" being the same as
+// "player1".  Players are kept in order of their index.
+func (l *Level) addPlayer(obj system.TiledObject) (err error) {
+	var (
+		index  int
+		player *Player
+	)
+	if obj.Type != "player" {
+		index = int(obj.Type[len(obj.Type)-1] - '1')
+	}
+	if l.GetPlayer(index) != nil {
+		return fmt.Errorf("More than one spawn for player %v", index+1)
+	}
+	player = NewPlayer(float64(obj.X), float64(obj.Y), DOWN|STOPPED, PLAYER_SPRITES[index])
+	player.Index = index
+	l.Cast.AddActor(player)
+	l.Players = append(l.Players, player)
+	for i := len(l.Players) - 1; i > 0 && l.Players[i-1].Index > index; i-- {
+		l.Players[i-1], l.Players[i] = l.Players[i], l.Players[i-1]
+	}
+	l.Player = l.Players[0]
+	l.spawnX = l.Player.x
+	l.spawnY = l.Player.y
+	return
+}
+
+func (l *Level) addEnemy(obj system.TiledObject, name string) (err error) {
+	var (
+		arch  *EnemyArchetype
+		enemy *Enemy
+		ok    bool
+	)
+	if arch, ok = l.archetypes[name]; !ok {
+		return fmt.Errorf("Unknown enemy archetype %v", name)
+	}
+	enemy = NewEnemy(float64(obj.X), float64(obj.Y), DOWN|STOPPED, arch, l.Random.Stream("enemy"))
+	if enemy.Behavior, err = l.getBehavior(arch.getBehaviorProperties(obj.Properties)); err != nil {
+		return
+	}
+	l.enemies = append(l.enemies, enemy)
+	l.enemyIndex.Update(enemy, l.getActorIndices(enemy.Player.Actor))
+	l.Cast.AddActor(enemy)
+	return
+}
+
+// How a level is ending.  ENDING_DONE means the sequence has finished and
+// Won or Died has been set.
+const (
+	ENDING_NONE = iota
+	ENDING_DIED
+	ENDING_WON
+	ENDING_DONE
+)
+
 const (
 	TILE_GRASS = 1 + iota
 	TILE_STONE
@@ -488,35 +1166,40 @@ const (
 	TILE_BREAKABLE_STONE_2
 )
 
+// Names used for tiles in data/animations.json.
+var TILE_NAMES = map[string]int{
+	"grass":             TILE_GRASS,
+	"stone":             TILE_STONE,
+	"brick":             TILE_BRICK,
+	"breakable_stone_1": TILE_BREAKABLE_STONE_1,
+	"breakable_stone_2": TILE_BREAKABLE_STONE_2,
+}
+
+// Tile animations are set by LoadSprites.
 var TILES = map[int]TileType{
 	TILE_GRASS: TileType{
-		Anim:      system.Anim([]int{1}, 4),
 		Passable:  true,
 		Breakable: false,
 		StopsFire: false,
 	},
 	TILE_STONE: TileType{
-		Anim:      system.Anim([]int{2}, 4),
 		Passable:  false,
 		Breakable: false,
 		StopsFire: true,
 	},
 	TILE_BREAKABLE_STONE_1: TileType{
-		Anim:      system.Anim([]int{4}, 4),
 		Passable:  false,
 		Breakable: true,
 		StopsFire: true,
 		NextState: TILE_BREAKABLE_STONE_2,
 	},
 	TILE_BREAKABLE_STONE_2: TileType{
-		Anim:      system.Anim([]int{5}, 4),
 		Passable:  false,
 		Breakable: true,
 		StopsFire: true,
 		NextState: TILE_GRASS,
 	},
 	TILE_BRICK: TileType{
-		Anim:      system.Anim([]int{3}, 16),
 		Passable:  false,
 		Breakable: true,
 		StopsFire: true,
@@ -525,7 +1208,7 @@ var TILES = map[int]TileType{
 }
 
 type TileType struct {
-	Anim      *system.Animation
+	Anim      *system.AnimationPlayer
 	Passable  bool
 	Breakable bool
 	StopsFire bool
@@ -0,0 +1,151 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"strconv"
+)
+
+// Radii are in tiles.
+const (
+	PLAYER_LIGHT_RADIUS = 4
+	FIRE_LIGHT_RADIUS   = 2
+	TORCH_LIGHT_RADIUS  = 3
+)
+
+// How bright tiles the player has seen before stay once they're out of
+// sight.
+const FOG_LIGHT = 0.2
+
+type Light struct {
+	Tile   int
+	Radius int
+}
+
+// Reads a torch placed in the map.  The radius property overrides the
+// default.
+func (l *Level) parseTorch(x int, y int, props map[string]string) (light Light, err error) {
+	light = Light{
+		Tile:   l.getPixelIndex(x, y),
+		Radius: TORCH_LIGHT_RADIUS,
+	}
+	if raw, ok := props["radius"]; ok {
+		light.Radius, err = strconv.Atoi(raw)
+	}
+	return
+}
+
+// Recomputes how brightly lit each tile is.  Tiles are lit by every light
+// with a clear line to them, fading out towards the edge of its radius.
+// Does nothing unless the level is dark.
+func (l *Level) updateLight() {
+	if !l.Dark {
+		return
+	}
+	for i := range l.Light {
+		l.Light[i] = 0
+	}
+	for _, light := range l.getLights() {
+		l.addLight(light)
+	}
+	for i, v := range l.Light {
+		if v > 0 {
+			l.seen[i] = true
+		} else if l.seen[i] {
+			l.Light[i] = FOG_LIGHT
+		}
+	}
+}
+
+func (l *Level) getLights() (out []Light) {
+	out = append(out, l.torches...)
+	for _, p := range l.Players {
+		out = append(out, Light{l.getActorIndex(p.Actor), PLAYER_LIGHT_RADIUS})
+	}
+	for i, f := range l.fire {
+		if f != nil {
+			out = append(out, Light{i, FIRE_LIGHT_RADIUS})
+		}
+	}
+	return
+}
+
+func (l *Level) addLight(light Light) {
+	var (
+		x = l.iToX(light.Tile)
+		y = l.iToY(light.Tile)
+		r = float64(light.Radius) + 1
+	)
+	for ty := y - light.Radius; ty <= y+light.Radius; ty++ {
+		for tx := x - light.Radius; tx <= x+light.Radius; tx++ {
+			if tx < 0 || ty < 0 || tx >= l.Map.Width || ty >= l.Map.Height {
+				continue
+			}
+			var (
+				i = l.xyToI(tx, ty)
+				d = math.Hypot(float64(tx-x), float64(ty-y))
+				v = 1 - d/r
+			)
+			if v <= l.Light[i] || !l.canSee(light.Tile, i) {
+				continue
+			}
+			l.Light[i] = v
+		}
+	}
+}
+
+// Whether a straight line between the two tiles passes only through tiles
+// which let light through.  The end tiles themselves don't count, so walls
+// next to a light are lit.
+func (l *Level) canSee(from int, to int) bool {
+	var (
+		x0 = l.iToX(from)
+		y0 = l.iToY(from)
+		x1 = l.iToX(to)
+		y1 = l.iToY(to)
+		dx = x1 - x0
+		dy = y1 - y0
+		sx = 1
+		sy = 1
+	)
+	if dx < 0 {
+		dx, sx = -dx, -1
+	}
+	if dy < 0 {
+		dy, sy = -dy, -1
+	}
+	var err = dx - dy
+	for x0 != x1 || y0 != y1 {
+		var e2 = 2 * err
+		if e2 > -dy {
+			err -= dy
+			x0 += sx
+		}
+		if e2 < dx {
+			err += dx
+			y0 += sy
+		}
+		if (x0 != x1 || y0 != y1) && l.tileOpaque(l.xyToI(x0, y0)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *Level) tileOpaque(i int) bool {
+	var t, err = l.getTile(i)
+	return err != nil || !TILES[t.Type].Passable
+}
@@ -0,0 +1,300 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+)
+
+const (
+	NET_ADDR        = "localhost:7777"
+	NET_PLAYERS     = 2
+	NET_INPUT_DELAY = 4 // Ticks between an action and the tick it's applied on
+	NET_FRAMES      = 64
+)
+
+// Sent by the host once a player joins.
+type NetHello struct {
+	Seed    int64
+	Players int
+	Index   int // The joining player's
+}
+
+// One player's actions for a tick.  Each frame also carries the checksum of
+// the sender's level at SumTick so the two machines can tell if they drift
+// apart.
+type NetFrame struct {
+	Level   int
+	Tick    int
+	Events  []ReplayEvent
+	SumTick int
+	Sum     uint32
+}
+
+type netKey struct {
+	level int
+	tick  int
+}
+
+// Runs levels in lockstep with another machine, only ever exchanging
+// actions.  Actions are sent NET_INPUT_DELAY ticks before they're applied,
+// which hides that much latency, and a tick only runs once both players'
+// actions for it are in.  Once anything goes wrong the match is over, and
+// every Step after returns the same error.
+type Lockstep struct {
+	Index    int // This machine's player
+	Seed     int64
+	Players  int
+	Reason   string // Why the match ended, if it has
+	conn     net.Conn
+	enc      *json.Encoder
+	level    int
+	sent     int
+	pending  []ReplayEvent // Taken while waiting, sent with the next frame
+	err      error
+	local    map[netKey][]ReplayEvent
+	remote   map[netKey][]ReplayEvent
+	sums     map[netKey]uint32
+	theirs   map[netKey]uint32
+	incoming chan *NetFrame
+	errs     chan error
+}
+
+func newLockstep(conn net.Conn, index int, hello NetHello) *Lockstep {
+	return &Lockstep{
+		Index:    index,
+		Seed:     hello.Seed,
+		Players:  hello.Players,
+		conn:     conn,
+		enc:      json.NewEncoder(conn),
+		local:    map[netKey][]ReplayEvent{},
+		remote:   map[netKey][]ReplayEvent{},
+		sums:     map[netKey]uint32{},
+		theirs:   map[netKey]uint32{},
+		incoming: make(chan *NetFrame, NET_FRAMES),
+		errs:     make(chan error, 1),
+	}
+}
+
+// Waits for a player to join, then plays as the first player.  Gives up
+// if cancel is closed first.
+func HostLockstep(addr string, seed int64, cancel chan struct{}) (n *Lockstep, err error) {
+	var (
+		ln    net.Listener
+		conn  net.Conn
+		hello = NetHello{Seed: seed, Players: NET_PLAYERS, Index: 1}
+		done  = make(chan struct{})
+	)
+	log.Printf("Waiting for a player on %v\n", addr)
+	if ln, err = net.Listen("tcp", addr); err != nil {
+		return
+	}
+	defer ln.Close()
+	defer close(done)
+	go closeOnCancel(ln, cancel, done)
+	if conn, err = ln.Accept(); err != nil {
+		return
+	}
+	log.Printf("Player joined from %v, seed %v\n", conn.RemoteAddr(), seed)
+	n = newLockstep(conn, 0, hello)
+	if err = n.enc.Encode(hello); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go n.read(json.NewDecoder(conn))
+	return
+}
+
+// Joins a host, playing as whichever player it says.  Gives up if cancel
+// is closed first.
+func JoinLockstep(addr string, cancel chan struct{}) (n *Lockstep, err error) {
+	var (
+		dialer  = net.Dialer{Cancel: cancel}
+		conn    net.Conn
+		decoder *json.Decoder
+		hello   NetHello
+		done    = make(chan struct{})
+	)
+	log.Printf("Joining %v\n", addr)
+	if conn, err = dialer.Dial("tcp", addr); err != nil {
+		return
+	}
+	defer close(done)
+	go closeOnCancel(conn, cancel, done)
+	decoder = json.NewDecoder(conn)
+	if err = decoder.Decode(&hello); err != nil {
+		conn.Close()
+		return
+	}
+	log.Printf("Joined %v as player %v, seed %v\n", addr, hello.Index+1, hello.Seed)
+	n = newLockstep(conn, hello.Index, hello)
+	go n.read(decoder)
+	return
+}
+
+// Closes c if cancel is closed before done is, to stop a blocked call.
+func closeOnCancel(c io.Closer, cancel chan struct{}, done chan struct{}) {
+	select {
+	case <-cancel:
+		c.Close()
+	case <-done:
+	}
+}
+
+func (n *Lockstep) Close() {
+	n.conn.Close()
+}
+
+// Starts counting ticks from zero for a new level.  Both machines load
+// levels at the same points, so the count stays in step.  Never called
+// while a Step is running.
+func (n *Lockstep) NextLevel() {
+	n.level++
+	n.sent = 0
+	for _, m := range []map[netKey][]ReplayEvent{n.local, n.remote} {
+		for k := range m {
+			if k.level < n.level {
+				delete(m, k)
+			}
+		}
+	}
+	for _, m := range []map[netKey]uint32{n.sums, n.theirs} {
+		for k := range m {
+			if k.level < n.level {
+				delete(m, k)
+			}
+		}
+	}
+}
+
+// Returns both players' actions for the level's next tick, or false if the
+// other player's haven't arrived yet.  Local actions are taken on every
+// call, so they never back up while waiting, and sent once per tick as this
+// machine's player.
+func (n *Lockstep) Step(l *Level, take func() []ReplayEvent) (events []ReplayEvent, ready bool, err error) {
+	if n.err == nil {
+		events, ready, n.err = n.step(l, take)
+	}
+	return events, ready, n.err
+}
+
+func (n *Lockstep) step(l *Level, take func() []ReplayEvent) (events []ReplayEvent, ready bool, err error) {
+	var (
+		now    = netKey{n.level, l.Ticks}
+		local  []ReplayEvent
+		remote []ReplayEvent
+		ok     bool
+	)
+	n.pending = append(n.pending, take()...)
+	if err = n.drain(); err != nil {
+		return
+	}
+	if n.sent == l.Ticks {
+		if err = n.send(l, n.pending); err != nil {
+			return
+		}
+		n.pending = nil
+	}
+	if remote, ok = n.remote[now]; !ok && l.Ticks >= NET_INPUT_DELAY {
+		return
+	}
+	local = n.local[now]
+	delete(n.local, now)
+	delete(n.remote, now)
+	if n.Index == 0 {
+		events = append(local, remote...)
+	} else {
+		events = append(remote, local...)
+	}
+	ready = true
+	return
+}
+
+func (n *Lockstep) send(l *Level, events []ReplayEvent) (err error) {
+	var f = &NetFrame{
+		Level:   n.level,
+		Tick:    l.Ticks + NET_INPUT_DELAY,
+		SumTick: l.Ticks,
+		Sum:     l.Checksum(),
+	}
+	for _, e := range events {
+		var _, action = SplitAction(e.Action)
+		e.Action = PlayerAction(n.Index, action)
+		f.Events = append(f.Events, e)
+	}
+	n.local[netKey{f.Level, f.Tick}] = f.Events
+	n.sums[netKey{f.Level, f.SumTick}] = f.Sum
+	n.sent = l.Ticks + 1
+	if err = n.enc.Encode(f); err != nil {
+		n.Reason = "lost connection"
+		return fmt.Errorf("Lost connection: %v", err)
+	}
+	return n.compare(netKey{f.Level, f.SumTick})
+}
+
+func (n *Lockstep) read(decoder *json.Decoder) {
+	for {
+		var f = &NetFrame{}
+		if err := decoder.Decode(f); err != nil {
+			n.errs <- err
+			return
+		}
+		n.incoming <- f
+	}
+}
+
+// Takes in whatever frames have arrived.
+func (n *Lockstep) drain() (err error) {
+	for {
+		select {
+		case f := <-n.incoming:
+			if f.Level < n.level {
+				continue
+			}
+			n.remote[netKey{f.Level, f.Tick}] = f.Events
+			n.theirs[netKey{f.Level, f.SumTick}] = f.Sum
+			if err = n.compare(netKey{f.Level, f.SumTick}); err != nil {
+				return
+			}
+		case err = <-n.errs:
+			n.Reason = "lost connection"
+			return fmt.Errorf("Lost connection: %v", err)
+		default:
+			return
+		}
+	}
+}
+
+// Checks the two machines agree on a tick, once both checksums are in.
+func (n *Lockstep) compare(k netKey) (err error) {
+	var (
+		mine, ok1   = n.sums[k]
+		theirs, ok2 = n.theirs[k]
+	)
+	if !ok1 || !ok2 {
+		return
+	}
+	delete(n.sums, k)
+	delete(n.theirs, k)
+	if mine != theirs {
+		n.Reason = "desynced"
+		err = fmt.Errorf("Desynced on level %v at tick %v, %08x here and %08x there", k.level, k.tick, mine, theirs)
+	}
+	return
+}
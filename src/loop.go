@@ -0,0 +1,85 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// How long the screen flashes after a respawn.
+const RESPAWN_FLASH = 300 * time.Millisecond
+
+// Reads the "loop" map property, the number of seconds between respawns in
+// loop mode.  Levels without it don't loop.
+func parseLoop(props map[string]string) (loop time.Duration, err error) {
+	var (
+		raw     string
+		ok      bool
+		seconds int
+	)
+	if raw, ok = props["loop"]; !ok {
+		return
+	}
+	if seconds, err = strconv.Atoi(raw); err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("Invalid loop length %v", raw)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// In loop mode the player is sent back to the start every loop.  Everything
+// else, including the player's powers, stays as it is.
+func (l *Level) updateLoop(diff time.Duration) {
+	if l.flash > 0 {
+		l.flash -= diff
+	}
+	if l.loop <= 0 {
+		return
+	}
+	if l.loopTimer += diff; l.loopTimer >= l.loop {
+		l.loopTimer -= l.loop
+		l.respawn()
+	}
+}
+
+func (l *Level) respawn() {
+	var p = l.Player
+	l.emit(EFFECT_RESPAWN, p.x+float64(l.TileWidth)/2, p.y+float64(l.TileHeight)/2)
+	p.x = l.spawnX
+	p.y = l.spawnY
+	p.Bomb = nil
+	p.State = DOWN | STOPPED
+	p.ClearDirections()
+	p.cooldown = HURT_COOLDOWN
+	l.emit(EFFECT_RESPAWN, p.x+float64(l.TileWidth)/2, p.y+float64(l.TileHeight)/2)
+	l.flash = RESPAWN_FLASH
+}
+
+// Time until the next respawn, or 0 if the level doesn't loop.
+func (l *Level) LoopRemaining() time.Duration {
+	if l.loop <= 0 {
+		return 0
+	}
+	return l.loop - l.loopTimer
+}
+
+// How strongly the screen should flash, fading from 1 to 0 after a respawn.
+func (l *Level) Flash() float64 {
+	if l.flash <= 0 {
+		return 0
+	}
+	return l.flash.Seconds() / RESPAWN_FLASH.Seconds()
+}
@@ -16,8 +16,10 @@ package main
 
 import (
 	"./system"
+	"encoding/json"
 	"flag"
 	"log"
+	"os"
 	"runtime"
 )
 
@@ -26,14 +28,51 @@ func init() {
 	runtime.LockOSThread()
 }
 
+var (
+	record  = flag.String("record", "", "Save a replay of the current level, or of a solved level's path, to this file")
+	replay  = flag.String("replay", "", "Play back a replay file, checking it as it goes")
+	seed    = flag.Int64("seed", 0, "Seed for every level's random numbers, 0 picks one")
+	players = flag.Int("players", 1, "Play a battle between 2 to 4 players")
+	addr    = flag.String("addr", NET_ADDR, "Address to host or join network matches on")
+	host    = flag.Bool("host", false, "Host a network match")
+	join    = flag.Bool("join", false, "Join a network match")
+	watch   = flag.String("spectate", "", "Stream the game as lines of JSON to spectators connecting here")
+	level   = flag.String("level", "", "Play only this map, such as the loop mode demo data/loop01.json")
+	sim     = flag.String("simulate", "", "Run this level without a window and print outcome statistics as JSON")
+	runs    = flag.Int("runs", 1, "How many times to run the level when simulating")
+	ticks   = flag.Int("ticks", SIMULATE_TICKS, "Most ticks each simulated run lasts")
+	script  = flag.String("script", "", "Replay file whose actions drive simulated runs instead of the bot")
+	solve   = flag.String("solve", "", "Check the goal of this level can be reached and print a path to it as JSON")
+)
+
 func main() {
 	var (
 		err  error
 		win  *system.Window
 		ctrl *system.Controller
 		game *Game
+		opts GameOptions
 	)
 	flag.Parse()
+	if *sim != "" {
+		simulate()
+		return
+	}
+	if *solve != "" {
+		solveLevel()
+		return
+	}
+	opts = GameOptions{
+		Record:  *record,
+		Replay:  *replay,
+		Seed:    *seed,
+		Players: *players,
+		Addr:    *addr,
+		Host:    *host,
+		Join:    *join,
+		Watch:   *watch,
+		Level:   *level,
+	}
 	if ctrl, err = system.NewController(); err != nil {
 		log.Fatalf("Couldn't init Controller: %v\n", err)
 	}
@@ -42,7 +81,7 @@ func main() {
 	if err = ctrl.Open(win); err != nil {
 		log.Fatalf("Couldn't open Window: %v\n", err)
 	}
-	if game, err = NewGame(ctrl); err != nil {
+	if game, err = NewGame(ctrl, opts); err != nil {
 		log.Fatalf("Couldn't start Game: %v\n", err)
 	}
 	defer game.Terminate()
@@ -50,3 +89,45 @@ func main() {
 	log.Printf("Exiting peacefully")
 	log.Printf("%v", win)
 }
+
+func simulate() {
+	var (
+		err    error
+		result *SimulationResult
+		data   []byte
+	)
+	if result, err = Simulate(SimulationOptions{
+		Level:  *sim,
+		Runs:   *runs,
+		Ticks:  *ticks,
+		Seed:   *seed,
+		Script: *script,
+	}); err != nil {
+		log.Fatalf("Couldn't simulate: %v\n", err)
+	}
+	if data, err = json.MarshalIndent(result, "", " "); err != nil {
+		log.Fatalf("Couldn't encode results: %v\n", err)
+	}
+	os.Stdout.Write(append(data, '\n'))
+}
+
+func solveLevel() {
+	var (
+		err    error
+		result *SolveResult
+		solver *Solver
+		data   []byte
+	)
+	if result, solver, err = SolveLevel(*solve, *seed); err != nil {
+		log.Fatalf("Couldn't solve: %v\n", err)
+	}
+	if *record != "" && result.Solvable {
+		if err = solver.Replay(result, *seed).Save(*record); err != nil {
+			log.Fatalf("Couldn't save path: %v\n", err)
+		}
+	}
+	if data, err = json.MarshalIndent(result, "", " "); err != nil {
+		log.Fatalf("Couldn't encode results: %v\n", err)
+	}
+	os.Stdout.Write(append(data, '\n'))
+}
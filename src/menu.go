@@ -49,6 +49,9 @@ func LoadMenu(path string, handler MenuHandler) (out *BasicMenu, err error) {
 	if tm, err = system.LoadMap(path); err != nil {
 		return
 	}
+	if err = tm.LoadTextures(); err != nil {
+		return
+	}
 	cw = float64(tm.Width * tm.Tilewidth)
 	ch = float64(tm.Height * tm.Tileheight)
 	out = &BasicMenu{
@@ -264,4 +267,7 @@ type Button struct {
 const (
 	BUTTON_START = 0
 	BUTTON_EXIT  = 2
+	BUTTON_BACK  = 3
+	BUTTON_HOST  = 4
+	BUTTON_JOIN  = 5
 )
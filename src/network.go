@@ -0,0 +1,231 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"./system"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Lets a player host a versus match or join one at Addr.
+type NetworkMenu struct {
+	*BasicMenu
+	Font     *system.Font
+	Addr     string
+	Status   string
+	Busy     bool
+	selected int
+}
+
+var NETWORK_CHOICES = []int{BUTTON_HOST, BUTTON_JOIN, BUTTON_BACK}
+
+func LoadNetworkMenu(path string, handler MenuHandler, font *system.Font, addr string) (out *NetworkMenu, err error) {
+	var menu *BasicMenu
+	if menu, err = LoadMenu(path, handler); err != nil {
+		return
+	}
+	out = &NetworkMenu{
+		BasicMenu: menu,
+		Font:      font,
+		Addr:      addr,
+	}
+	return
+}
+
+func (m *NetworkMenu) Select(i int) {
+	m.selected = i % len(NETWORK_CHOICES)
+}
+
+func (m *NetworkMenu) SelectNext() {
+	m.Select(m.selected + 1)
+}
+
+func (m *NetworkMenu) SelectPrev() {
+	m.Select(m.selected + len(NETWORK_CHOICES) - 1)
+}
+
+// Nothing can be chosen while connecting, other than going back.
+func (m *NetworkMenu) Choose() {
+	var choice = NETWORK_CHOICES[m.selected]
+	if m.Busy && choice != BUTTON_BACK {
+		return
+	}
+	m.Handler(choice)
+}
+
+func (m *NetworkMenu) Draw() {
+	m.Font.Printf(64, 64, "%v host on %v", m.marker(0), m.Addr)
+	m.Font.Printf(64, 104, "%v join %v", m.marker(1), m.Addr)
+	m.Font.Printf(64, 144, "%v back", m.marker(2))
+	m.Font.Printf(64, 224, "%v", m.Status)
+}
+
+func (m *NetworkMenu) marker(i int) string {
+	if i == m.selected {
+		return ">"
+	}
+	return " "
+}
+
+// How connecting went, or why a match ended, sent back to the paint loop.
+type connection struct {
+	net     *Lockstep
+	err     error
+	dialing chan struct{} // Which try at connecting this was
+}
+
+// What the game was playing before a network match, to go back to after.
+type offline struct {
+	Maps       []string
+	LevelIndex int
+	Options    GameOptions
+	Battle     *Battle
+}
+
+// Opens the network screen, which goes back to whatever was showing.
+func (g *Game) showNetwork() {
+	if g.Menu == g.Network {
+		return
+	}
+	g.lastMenu = g.Menu
+	g.Network.Select(0)
+	g.Menu = g.Network
+}
+
+// Connecting blocks, so it happens off the paint loop.  The match starts
+// from checkNetwork once it's done.
+func (g *Game) hostGame() {
+	g.connect(fmt.Sprintf("Waiting for a player on %v", g.Network.Addr), func(cancel chan struct{}) (*Lockstep, error) {
+		return HostLockstep(g.Network.Addr, time.Now().UnixNano(), cancel)
+	})
+}
+
+func (g *Game) joinGame() {
+	g.connect(fmt.Sprintf("Joining %v", g.Network.Addr), func(cancel chan struct{}) (*Lockstep, error) {
+		return JoinLockstep(g.Network.Addr, cancel)
+	})
+}
+
+func (g *Game) connect(status string, dial func(chan struct{}) (*Lockstep, error)) {
+	var cancel = make(chan struct{})
+	g.showNetwork()
+	g.Network.Status = status
+	g.Network.Busy = true
+	g.dialing = cancel
+	go func() {
+		var n, err = dial(cancel)
+		g.connected <- connection{n, err, cancel}
+	}()
+}
+
+// Gives up on connecting, if it's still going on.
+func (g *Game) cancelConnect() {
+	if g.dialing == nil {
+		return
+	}
+	close(g.dialing)
+	g.dialing = nil
+	g.Network.Busy = false
+	g.Network.Status = ""
+}
+
+func (g *Game) checkNetwork() {
+	select {
+	case c := <-g.connected:
+		if c.dialing != g.dialing {
+			// Given up on already.
+			if c.net != nil {
+				c.net.Close()
+			}
+			return
+		}
+		g.dialing = nil
+		g.Network.Busy = false
+		if c.err != nil {
+			log.Printf("Couldn't connect: %v\n", c.err)
+			g.Network.Status = "Couldn't connect"
+			return
+		}
+		g.Network.Status = ""
+		g.startNetwork(c.net)
+	case c := <-g.dropped:
+		if c.net == g.Net {
+			g.stopNetwork(c.err)
+		}
+	default:
+	}
+}
+
+// Starts a versus match with everyone using the host's seed, so both
+// machines build the same levels.
+func (g *Game) startNetwork(n *Lockstep) {
+	var (
+		battle *Battle
+		err    error
+	)
+	if battle, err = NewBattle(n.Players); err != nil {
+		n.Close()
+		log.Printf("Couldn't start match: %v\n", err)
+		g.Network.Status = "Couldn't start match"
+		return
+	}
+	g.ticking.Lock()
+	g.offline = &offline{g.Maps, g.LevelIndex, g.Options, g.Battle}
+	g.Net = n
+	g.Options.Seed = n.Seed
+	g.Options.Players = n.Players
+	g.Battle = battle
+	g.Maps = BATTLE_MAPS
+	g.LevelIndex = 0
+	g.ticking.Unlock()
+	if err = g.setLevel(); err != nil {
+		g.stopNetwork(err)
+	}
+}
+
+// Called from the update loop when a match can't go on, which is once the
+// machines disagree or lose touch.  The paint loop picks it up.
+func (g *Game) dropNetwork(err error) {
+	select {
+	case g.dropped <- connection{net: g.Net, err: err}:
+	default:
+	}
+}
+
+// Ends the match and goes back to whatever was being played before, with
+// the network screen saying why.
+func (g *Game) stopNetwork(err error) {
+	log.Printf("Network match over: %v\n", err)
+	g.saveReplay()
+	g.ticking.Lock()
+	g.Network.Status = "Match over"
+	if g.Net.Reason != "" {
+		g.Network.Status = "Match over, " + g.Net.Reason
+	}
+	g.Net.Close()
+	g.Net = nil
+	g.Maps = g.offline.Maps
+	g.LevelIndex = g.offline.LevelIndex
+	g.Options = g.offline.Options
+	g.Battle = g.offline.Battle
+	g.offline = nil
+	g.ticking.Unlock()
+	if err = g.setLevel(); err != nil {
+		log.Printf("Couldn't go back to %v: %v\n", g.Maps[g.LevelIndex%len(g.Maps)], err)
+	}
+	g.showNetwork()
+}
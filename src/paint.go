@@ -18,6 +18,7 @@ import (
 	"./system"
 	"github.com/go-gl/gl"
 	"github.com/go-gl/glfw"
+	"math"
 )
 
 func BeginPaint() {
@@ -32,11 +33,15 @@ func EndPaint() {
 func PaintCast(ctrl *system.Controller, c *Cast) {
 	c.Texture.Bind()
 	for _, a := range c.Actors {
+		var scale = 1
+		if s, ok := a.(system.Scalable); ok {
+			scale = s.Scale()
+		}
 		var (
-			minx  = int(a.X()) - c.OffsetX
-			miny  = int(a.Y()) - c.OffsetY
-			maxx  = minx + c.Width
-			maxy  = miny + c.Height
+			minx  = int(a.X()) - c.OffsetX*scale
+			miny  = int(a.Y()) - c.OffsetY*scale
+			maxx  = minx + c.Width*scale
+			maxy  = miny + c.Height*scale
 			frame = a.GetFrame() + c.TextureCols*a.TextureRow()
 		)
 		if a.FlipX() {
@@ -49,6 +54,79 @@ func PaintCast(ctrl *system.Controller, c *Cast) {
 	c.Texture.Unbind()
 }
 
+// Particles are painted with the cast's texture, scaled down and centered
+// on the tile area of the frame.
+func PaintParticles(ctrl *system.Controller, c *Cast, ps *system.ParticleSystem) {
+	c.Texture.Bind()
+	for _, p := range ps.Snapshot() {
+		var (
+			size  = p.Size()
+			color = p.Color()
+			cx    = float64(c.OffsetX+c.Width) / 2.0 * size
+			cy    = float64(c.OffsetY+c.Height) / 2.0 * size
+			minx  = int(p.X() - cx)
+			miny  = int(p.Y() - cy)
+			maxx  = minx + int(float64(c.Width)*size)
+			maxy  = miny + int(float64(c.Height)*size)
+			frame = p.GetFrame() + c.TextureCols*p.TextureRow()
+		)
+		gl.Color4f(color.R, color.G, color.B, color.A)
+		paintSprite(minx, miny, maxx, maxy, c.Texture, frame)
+	}
+	gl.Color4f(1, 1, 1, 1)
+	c.Texture.Unbind()
+}
+
+// Darkens each tile of a dark level according to how brightly it's lit.
+func PaintLight(ctrl *system.Controller, l *Level) {
+	if !l.Dark {
+		return
+	}
+	gl.Disable(gl.TEXTURE_2D)
+	gl.Begin(gl.QUADS)
+	for i, v := range l.Light {
+		var (
+			minx = l.iToX(i) * l.TileWidth
+			miny = l.iToY(i) * l.TileHeight
+			maxx = minx + l.TileWidth
+			maxy = miny + l.TileHeight
+		)
+		gl.Color4f(0, 0, 0, float32(1-v))
+		gl.Vertex2i(minx, miny)
+		gl.Vertex2i(maxx, miny)
+		gl.Vertex2i(maxx, maxy)
+		gl.Vertex2i(minx, maxy)
+	}
+	gl.End()
+	gl.Color4f(1, 1, 1, 1)
+	gl.Enable(gl.TEXTURE_2D)
+}
+
+// Shows the countdown to the next respawn in loop mode, and the flash
+// which follows one.
+func PaintHud(ctrl *system.Controller, font *system.Font, l *Level) {
+	var remaining = l.LoopRemaining()
+	if flash := l.Flash(); flash > 0 {
+		var (
+			w = l.Map.Width * l.TileWidth
+			h = l.Map.Height * l.TileHeight
+		)
+		gl.Disable(gl.TEXTURE_2D)
+		gl.Color4f(1, 1, 1, float32(flash*0.6))
+		gl.Begin(gl.QUADS)
+		gl.Vertex2i(0, 0)
+		gl.Vertex2i(w, 0)
+		gl.Vertex2i(w, h)
+		gl.Vertex2i(0, h)
+		gl.End()
+		gl.Color4f(1, 1, 1, 1)
+		gl.Enable(gl.TEXTURE_2D)
+	}
+	if remaining > 0 {
+		font.Printf(16, 16, "%v", int(math.Ceil(remaining.Seconds())))
+	}
+}
+
 func PaintMenu(ctrl *system.Controller, menu Menu) {
 	PaintMap(ctrl, menu.GetMap())
 	menu.Draw()
@@ -0,0 +1,159 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+)
+
+// Breadth first search over the tiles an actor could walk through, starting
+// at tile index start.  Returns the predecessor of every tile reached and
+// the tiles in the order they were visited.
+func (l *Level) SearchTiles(a *Actor, start int) (prev map[int]int, order []int) {
+	var (
+		queue = []int{start}
+		i     int
+	)
+	prev = map[int]int{start: start}
+	for len(queue) > 0 {
+		i, queue = queue[0], queue[1:]
+		order = append(order, i)
+		for _, j := range l.getNeighbors(i) {
+			if _, seen := prev[j]; seen {
+				continue
+			}
+			if !l.TileWalkable(a, j) {
+				continue
+			}
+			prev[j] = i
+			queue = append(queue, j)
+		}
+	}
+	return
+}
+
+// Returns the tile indices leading from start to goal, excluding start, or
+// nil if goal can't be reached.
+func (l *Level) FindPath(a *Actor, start int, goal int) []int {
+	var prev, _ = l.SearchTiles(a, start)
+	return getPath(prev, start, goal)
+}
+
+// Returns the shortest path from start to a tile no blast will reach,
+// passing only through tiles the actor can cross before they catch fire.
+// Returns nil if there's no way out.
+func (l *Level) FindEscape(a *Actor, start int, danger []time.Duration) []int {
+	var (
+		step  = l.getStepTime(a)
+		queue = []int{start}
+		depth = map[int]int{start: 0}
+		prev  = map[int]int{start: start}
+		i     int
+	)
+	for len(queue) > 0 {
+		i, queue = queue[0], queue[1:]
+		if danger[i] == SAFE {
+			return getPath(prev, start, i)
+		}
+		for _, j := range l.getNeighbors(i) {
+			if _, seen := prev[j]; seen {
+				continue
+			}
+			if !l.TileWalkable(a, j) {
+				continue
+			}
+			if time.Duration(depth[i]+2)*step >= danger[j] {
+				// Would still be standing here when it goes up.
+				continue
+			}
+			prev[j] = i
+			depth[j] = depth[i] + 1
+			queue = append(queue, j)
+		}
+	}
+	return nil
+}
+
+// Whether an actor could walk onto the tile at index i.  Bombs block
+// everyone except the actor standing on them and enemies block each other.
+func (l *Level) TileWalkable(a *Actor, i int) bool {
+	var (
+		t   *Tile
+		b   *Bomb
+		err error
+	)
+	if t, err = l.getTile(i); err != nil || !l.tilePassable(a, t) {
+		return false
+	}
+	if b, _ = l.getBomb(i); b != nil && b != a.Bomb {
+		return false
+	}
+	for _, e := range l.enemyIndex.At(i) {
+		if e.Player.Actor != a {
+			return false
+		}
+	}
+	if l.Boss != nil && !l.Boss.Defeated() && l.Boss.Actor != a &&
+		l.Boss.Contains(l.getPixelFromIndex(i)) {
+		return false
+	}
+	return true
+}
+
+// Returns the in-bounds tile indices adjacent to index i.
+func (l *Level) getNeighbors(i int) (out []int) {
+	var (
+		x = l.iToX(i)
+		y = l.iToY(i)
+	)
+	if x > 0 {
+		out = append(out, l.xyToI(x-1, y))
+	}
+	if x < l.Map.Width-1 {
+		out = append(out, l.xyToI(x+1, y))
+	}
+	if y > 0 {
+		out = append(out, l.xyToI(x, y-1))
+	}
+	if y < l.Map.Height-1 {
+		out = append(out, l.xyToI(x, y+1))
+	}
+	return
+}
+
+// Returns the manhattan distance in tiles between two tile indices.
+func (l *Level) TileDistance(i int, j int) int {
+	var (
+		dx = l.iToX(i) - l.iToX(j)
+		dy = l.iToY(i) - l.iToY(j)
+	)
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	return dx + dy
+}
+
+func getPath(prev map[int]int, start int, goal int) (path []int) {
+	if _, ok := prev[goal]; !ok {
+		return nil
+	}
+	for i := goal; i != start; i = prev[i] {
+		path = append([]int{i}, path...)
+	}
+	return
+}
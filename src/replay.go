@@ -0,0 +1,170 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+)
+
+// How often, in ticks, a checksum of the level is recorded.
+const REPLAY_CHECKSUM_TICKS = 60
+
+// An action which happened just before the level's Tick'th update.
+type ReplayEvent struct {
+	Tick    int
+	Action  string
+	Pressed bool
+}
+
+type ReplayChecksum struct {
+	Tick int
+	Sum  uint32
+}
+
+// Everything needed to play a level back exactly as it went: the level, the
+// seed its enemies were given and every action along with its tick.
+// Checksums of the level are taken as it plays so playback can tell when it
+// has drifted.
+type Replay struct {
+	Level     string
+	Seed      int64
+	Players   int
+	Events    []ReplayEvent
+	Checksums []ReplayChecksum
+	next      int
+	checked   int
+	desynced  bool
+}
+
+func NewReplay(level string, seed int64, players int) *Replay {
+	return &Replay{
+		Level:   level,
+		Seed:    seed,
+		Players: players,
+	}
+}
+
+func LoadReplay(path string) (r *Replay, err error) {
+	log.Printf("Loading replay from %v\n", path)
+	err = loadJSON(path, &r)
+	return
+}
+
+func (r *Replay) Save(path string) (err error) {
+	var (
+		f    *os.File
+		data []byte
+	)
+	log.Printf("Saving replay to %v\n", path)
+	if data, err = json.MarshalIndent(r, "", " "); err != nil {
+		return
+	}
+	if f, err = os.Create(path); err != nil {
+		return
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return
+}
+
+func (r *Replay) Record(tick int, action string, pressed bool) {
+	r.Events = append(r.Events, ReplayEvent{tick, action, pressed})
+}
+
+// Records a checksum if one is due.
+func (r *Replay) Checksum(l *Level) {
+	if l.Ticks%REPLAY_CHECKSUM_TICKS == 0 {
+		r.Checksums = append(r.Checksums, ReplayChecksum{l.Ticks, l.Checksum()})
+	}
+}
+
+// Goes back to the start for another playback.
+func (r *Replay) Reset() {
+	r.next = 0
+	r.checked = 0
+	r.desynced = false
+}
+
+// Returns the events which happened on the tick.
+func (r *Replay) Next(tick int) (out []ReplayEvent) {
+	for r.next < len(r.Events) && r.Events[r.next].Tick <= tick {
+		if r.Events[r.next].Tick == tick {
+			out = append(out, r.Events[r.next])
+		}
+		r.next++
+	}
+	return
+}
+
+// Compares the level against the checksum recorded for its tick, if there
+// is one.  Only the first mismatch is reported since everything after it
+// will differ too.  Done is true once, when the last checksum is checked.
+func (r *Replay) Verify(l *Level) (done bool, err error) {
+	if r.checked == len(r.Checksums) {
+		return
+	}
+	for r.checked < len(r.Checksums) && r.Checksums[r.checked].Tick <= l.Ticks {
+		var c = r.Checksums[r.checked]
+		r.checked++
+		if c.Tick == l.Ticks && c.Sum != l.Checksum() && !r.desynced {
+			r.desynced = true
+			err = fmt.Errorf("Replay desynced at tick %v, expected %08x got %08x", c.Tick, c.Sum, l.Checksum())
+		}
+	}
+	done = r.checked == len(r.Checksums)
+	return
+}
+
+// Hashes the state a replay has to reproduce.  Particles, lighting and
+// animation frames only change how things look and are left out.
+func (l *Level) Checksum() uint32 {
+	var h = fnv.New32a()
+	fmt.Fprintln(h, l.Ticks, l.ending, l.loopTimer, l.history.Rewinding)
+	for _, t := range l.tiles {
+		fmt.Fprint(h, t.Type, " ")
+	}
+	for i, b := range l.bombs {
+		if b != nil {
+			fmt.Fprintln(h, "bomb", i, b.x, b.y, b.Elapsed, b.Sliding)
+		}
+	}
+	for _, b := range l.loose {
+		fmt.Fprintln(h, "loose", b.x, b.y, b.Elapsed, b.Flying)
+	}
+	for i, f := range l.fire {
+		if f != nil {
+			fmt.Fprintln(h, "fire", i, f.Elapsed)
+		}
+	}
+	for i, p := range l.pickups {
+		if p != nil {
+			fmt.Fprintln(h, "pickup", i, p.Power)
+		}
+	}
+	for _, p := range l.Players {
+		fmt.Fprintln(h, "player", p.Index, p.x, p.y, p.State, p.Health, p.Powers)
+	}
+	for _, e := range l.enemies {
+		fmt.Fprintln(h, "enemy", e.x, e.y, e.State, e.Health)
+	}
+	if b := l.Boss; b != nil {
+		fmt.Fprintln(h, "boss", b.x, b.y, b.State, b.Health, b.phase)
+	}
+	return h.Sum32()
+}
@@ -0,0 +1,220 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"./system"
+	"time"
+)
+
+const (
+	REWIND_WINDOW   = 10 * time.Second
+	REWIND_INTERVAL = 100 * time.Millisecond
+	REWIND_SPEED    = 2 // Times faster than real time
+)
+
+// Keeps snapshots of the level over the last REWIND_WINDOW, one every
+// REWIND_INTERVAL, so at most REWIND_WINDOW / REWIND_INTERVAL are held.
+type History struct {
+	snapshots []*Snapshot
+	elapsed   time.Duration
+	Rewinding bool
+}
+
+func NewHistory() *History {
+	return &History{
+		snapshots: make([]*Snapshot, 0, REWIND_WINDOW/REWIND_INTERVAL),
+	}
+}
+
+// Takes a snapshot if one is due, dropping the oldest once the window is
+// full.
+func (h *History) Record(l *Level, diff time.Duration) {
+	if h.elapsed += diff; h.elapsed < REWIND_INTERVAL && len(h.snapshots) > 0 {
+		return
+	}
+	h.elapsed = 0
+	if len(h.snapshots) == cap(h.snapshots) {
+		copy(h.snapshots, h.snapshots[1:])
+		h.snapshots = h.snapshots[:len(h.snapshots)-1]
+	}
+	h.snapshots = append(h.snapshots, NewSnapshot(l))
+}
+
+// Steps back through the snapshots, faster than they were taken.  Returns
+// false once there's nothing left to rewind.
+func (h *History) Rewind(l *Level, diff time.Duration) bool {
+	for h.elapsed += diff * REWIND_SPEED; h.elapsed >= REWIND_INTERVAL; h.elapsed -= REWIND_INTERVAL {
+		if len(h.snapshots) == 0 {
+			break
+		}
+		var last = len(h.snapshots) - 1
+		h.snapshots[last].Restore(l)
+		h.snapshots[last] = nil
+		h.snapshots = h.snapshots[:last]
+	}
+	return len(h.snapshots) > 0
+}
+
+func (h *History) Start() {
+	h.Rewinding = true
+	h.elapsed = 0
+}
+
+func (h *History) Stop() {
+	h.Rewinding = false
+	h.elapsed = 0
+}
+
+// Everything in the level which changes as it plays.  Actors are restored
+// in place, so references between them stay valid.
+type Snapshot struct {
+	tiles   []Tile
+	bombs   []*Bomb
+	fire    []*Fire
+	pickups []*Pickup
+	loose   []*Bomb
+	enemies []*Enemy
+	dying   []Mortal
+	players []*Player
+	cast    []system.Drawable
+	saved   []savedActor
+	ending  int
+	loop    time.Duration
+}
+
+// Writes back the copies of an actor's structs taken when it was saved.
+type savedActor func()
+
+func NewSnapshot(l *Level) (s *Snapshot) {
+	s = &Snapshot{
+		tiles:   append([]Tile{}, l.tiles...),
+		bombs:   append([]*Bomb{}, l.bombs...),
+		fire:    append([]*Fire{}, l.fire...),
+		pickups: append([]*Pickup{}, l.pickups...),
+		loose:   append([]*Bomb{}, l.loose...),
+		enemies: append([]*Enemy{}, l.enemies...),
+		dying:   append([]Mortal{}, l.dying...),
+		players: append([]*Player{}, l.Players...),
+		cast:    append([]system.Drawable{}, l.Cast.Actors...),
+		ending:  l.ending,
+		loop:    l.loopTimer,
+	}
+	for _, b := range append(l.getBombs(), l.loose...) {
+		s.saved = append(s.saved, saveBomb(b))
+	}
+	for _, f := range l.fire {
+		if f != nil {
+			s.saved = append(s.saved, saveFire(f))
+		}
+	}
+	for _, e := range l.enemies {
+		s.saved = append(s.saved, saveEnemy(e))
+	}
+	for _, p := range l.Players {
+		s.saved = append(s.saved, savePlayer(p))
+	}
+	if l.Boss != nil {
+		s.saved = append(s.saved, saveBoss(l.Boss))
+	}
+	return
+}
+
+func (s *Snapshot) Restore(l *Level) {
+	copy(l.tiles, s.tiles)
+	copy(l.bombs, s.bombs)
+	copy(l.fire, s.fire)
+	copy(l.pickups, s.pickups)
+	l.loose = append(l.loose[:0], s.loose...)
+	l.enemies = append(l.enemies[:0], s.enemies...)
+	l.dying = append(l.dying[:0], s.dying...)
+	l.Players = append(l.Players[:0], s.players...)
+	l.Cast.Actors = append(l.Cast.Actors[:0], s.cast...)
+	l.ending = s.ending
+	l.loopTimer = s.loop
+	for _, restore := range s.saved {
+		restore()
+	}
+	l.enemyIndex = NewEnemyIndex(len(l.tiles))
+	for _, e := range l.enemies {
+		l.enemyIndex.Update(e, l.getActorIndices(e.Player.Actor))
+	}
+	l.danger = l.GetDangerMap()
+}
+
+func saveActor(a *Actor) savedActor {
+	var v = *a
+	return func() { *a = v }
+}
+
+// Which directions are held is left as it is, since the keys are still
+// where they are now.
+func savePlayer(p *Player) savedActor {
+	var (
+		v     = *p
+		actor = saveActor(p.Actor)
+	)
+	return func() {
+		var held = p.held
+		*p = v
+		p.held = held
+		p.queued = 0
+		actor()
+	}
+}
+
+func saveEnemy(e *Enemy) savedActor {
+	var (
+		v      = *e
+		player = savePlayer(e.Player)
+	)
+	return func() {
+		*e = v
+		player()
+	}
+}
+
+func saveBoss(b *Boss) savedActor {
+	var (
+		v      = *b
+		player = savePlayer(b.Player)
+	)
+	return func() {
+		*b = v
+		player()
+	}
+}
+
+func saveBomb(b *Bomb) savedActor {
+	var (
+		v     = *b
+		actor = saveActor(b.Actor)
+	)
+	return func() {
+		*b = v
+		actor()
+	}
+}
+
+func saveFire(f *Fire) savedActor {
+	var (
+		v     = *f
+		actor = saveActor(f.Actor)
+	)
+	return func() {
+		*f = v
+		actor()
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Longest a headless run lasts by default, in ticks.
+const SIMULATE_TICKS = 120 * UPDATE_HZ
+
+const (
+	OUTCOME_WON     = "won"
+	OUTCOME_DIED    = "died"
+	OUTCOME_TIMEOUT = "timeout"
+)
+
+type SimulationOptions struct {
+	Level  string // The map to run
+	Runs   int
+	Ticks  int    // Most ticks a run lasts
+	Seed   int64  // Seed for the first run, each run after adds one
+	Script string // Replay file whose actions are played instead of the bot's
+}
+
+// How one run went.
+type SimulationRun struct {
+	Seed         int64
+	Outcome      string
+	Ticks        int
+	Bombs        int
+	BricksBroken int
+	Enemies      int
+	EnemiesLeft  int
+}
+
+// Outcome statistics across every run.  Means of ticks are over the runs
+// with that outcome.
+type SimulationResult struct {
+	Level           string
+	Runs            int
+	Won             int
+	Died            int
+	TimedOut        int
+	WinRate         float64
+	MeanTicksToWin  float64
+	MeanTicksToDie  float64
+	MeanBombs       float64
+	MeanEnemiesLeft float64 // Bosses spawn more as they go
+	Results         []SimulationRun
+}
+
+// Runs a level without a window as fast as it'll go, over and over, with
+// the player driven by a Bot or a script.
+func Simulate(opts SimulationOptions) (out *SimulationResult, err error) {
+	var (
+		archetypes Archetypes
+		bosses     Bosses
+		script     *Replay
+		run        SimulationRun
+		seed       = opts.Seed
+	)
+	if opts.Runs < 1 || opts.Ticks < 1 {
+		return nil, fmt.Errorf("Need at least one run of one tick, got %v of %v", opts.Runs, opts.Ticks)
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	if err = LoadSprites("data/animations.json"); err != nil {
+		return
+	}
+	if archetypes, err = LoadArchetypes("data/enemies.json"); err != nil {
+		return
+	}
+	if bosses, err = LoadBosses("data/bosses.json"); err != nil {
+		return
+	}
+	if opts.Script != "" {
+		if script, err = LoadReplay(opts.Script); err != nil {
+			return
+		}
+	}
+	out = &SimulationResult{Level: opts.Level, Runs: opts.Runs}
+	for i := 0; i < opts.Runs; i++ {
+		if run, err = simulateRun(opts, seed+int64(i), archetypes, bosses, script); err != nil {
+			return nil, err
+		}
+		out.add(run)
+	}
+	out.finish()
+	return
+}
+
+func simulateRun(opts SimulationOptions, seed int64, archetypes Archetypes, bosses Bosses, script *Replay) (run SimulationRun, err error) {
+	var (
+		level  *Level
+		bot    = NewBot(0)
+		diff   = time.Second / time.Duration(UPDATE_HZ)
+		bricks []bool
+		events []ReplayEvent
+		placed int
+	)
+	if level, err = LoadLevel(opts.Level, &Cast{}, archetypes, bosses, seed, func(string) {}); err != nil {
+		return
+	}
+	if script != nil {
+		script.Reset()
+	}
+	run = SimulationRun{Seed: seed, Outcome: OUTCOME_TIMEOUT, Enemies: len(level.enemies)}
+	for _, t := range level.tiles {
+		bricks = append(bricks, TILES[t.Type].Breakable)
+	}
+	for level.Ticks < opts.Ticks && !level.Won && !level.Died {
+		if script != nil {
+			events = script.Next(level.Ticks)
+		} else {
+			events = bot.Next(level)
+		}
+		for _, e := range events {
+			// Only count presses which put a bomb down, not ones on top of
+			// a bomb or over the limit.
+			placed = len(level.getBombs())
+			level.ApplyAction(e.Action, e.Pressed)
+			if _, action := SplitAction(e.Action); action == ACTION_PLACE_BOMB && len(level.getBombs()) > placed {
+				run.Bombs++
+			}
+		}
+		level.Update(diff)
+	}
+	switch {
+	case level.Won:
+		run.Outcome = OUTCOME_WON
+	case level.Died:
+		run.Outcome = OUTCOME_DIED
+	}
+	run.Ticks = level.Ticks
+	run.EnemiesLeft = len(level.enemies)
+	for i, t := range level.tiles {
+		if bricks[i] && !TILES[t.Type].Breakable {
+			run.BricksBroken++
+		}
+	}
+	log.Printf("Run with seed %v %v after %v ticks\n", seed, run.Outcome, run.Ticks)
+	return
+}
+
+func (r *SimulationResult) add(run SimulationRun) {
+	switch run.Outcome {
+	case OUTCOME_WON:
+		r.Won++
+		r.MeanTicksToWin += float64(run.Ticks)
+	case OUTCOME_DIED:
+		r.Died++
+		r.MeanTicksToDie += float64(run.Ticks)
+	default:
+		r.TimedOut++
+	}
+	r.MeanBombs += float64(run.Bombs)
+	r.MeanEnemiesLeft += float64(run.EnemiesLeft)
+	r.Results = append(r.Results, run)
+}
+
+// Turns the totals add kept into means.
+func (r *SimulationResult) finish() {
+	if r.Won > 0 {
+		r.MeanTicksToWin /= float64(r.Won)
+	}
+	if r.Died > 0 {
+		r.MeanTicksToDie /= float64(r.Died)
+	}
+	r.WinRate = float64(r.Won) / float64(r.Runs)
+	r.MeanBombs /= float64(r.Runs)
+	r.MeanEnemiesLeft /= float64(r.Runs)
+}
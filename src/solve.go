@@ -0,0 +1,635 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// Most quiet states to search on from before giving up.
+const SOLVE_STATES = 10000
+
+// One step of a witness path: the actions taken at its start and the tile
+// the player is on at its end.
+type SolveStep struct {
+	Actions []string
+	X       int
+	Y       int
+	Respawn bool `json:",omitempty"` // Sent back to the start during the step
+}
+
+type SolveResult struct {
+	Level    string
+	Solvable bool
+	Complete bool // Whether Solvable holds for the whole game, not just the search
+	Bombs    int
+	Steps    int
+	Ticks    int
+	States   int
+	Ignored  []string // Whatever the search leaves out
+	Path     []SolveStep
+}
+
+// A quiet moment, with no bomb lit and nothing burning.  Only tiles which
+// can break are kept, in bricks.
+type solveState struct {
+	pos     int
+	timer   int // Ticks into the loop
+	bricks  []int
+	regions []int // From regions, for bricks
+}
+
+// Steps aren't kept while searching, they're worked out again by retrace
+// for the path found.
+type solveNode struct {
+	state  *solveState
+	parent int
+	site   int // Where the bomb was lit, or -1 for waiting out the loop
+}
+
+// Searches for a way to the goal, lighting one bomb at a time to break
+// bricks.  Time passes in steps, however long the player takes to cross a
+// tile, and bombs follow the same rules as Explode and addFire.  The
+// player is taken to be burnt if fire touches either tile of a step at any
+// point in it, so any path found works in the game.  Enemies, the boss,
+// powerups and bombs lit together are left out, so a level is only shown to
+// be unsolvable if breaking every brick still wouldn't open the way.
+type Solver struct {
+	Level     *Level
+	MaxStates int
+	step      int // Ticks, as is every time below
+	fuse      int
+	burn      int
+	radius    int
+	loop      int
+	goal      int
+	spawn     int
+	breakable int
+	slots     []int           // Each tile's place in bricks, or -1 if it can't break
+	lit       map[string]bool // Bombs already tried, by spot
+}
+
+// Standing still, then each way the player can walk.
+var SOLVE_DIRS = []int{0, UP, DOWN, LEFT, RIGHT}
+
+func NewSolver(l *Level) (s *Solver, err error) {
+	var bomb = NewBomb(0, 0)
+	if l.Player == nil {
+		return nil, fmt.Errorf("Level %v has no player", l.Path)
+	}
+	if l.Goal == nil {
+		return nil, fmt.Errorf("Level %v has no goal to reach", l.Path)
+	}
+	s = &Solver{
+		Level:     l,
+		MaxStates: SOLVE_STATES,
+		step:      int(math.Ceil(float64(l.TileWidth) / l.Player.Rate * float64(UPDATE_HZ))),
+		fuse:      toTicks(bomb.Expires),
+		burn:      toTicks(NewFire(0, 0).Expires),
+		radius:    bomb.Radius,
+		loop:      toTicks(l.loop),
+		goal:      l.getActorIndex(l.Goal),
+		spawn:     l.getActorIndex(l.Player.Actor),
+		slots:     make([]int, len(l.tiles)),
+	}
+	for i, t := range l.tiles {
+		if s.slots[i] = -1; TILES[t.Type].Breakable {
+			s.slots[i] = s.breakable
+			s.breakable++
+		}
+	}
+	return
+}
+
+// Breadth first over quiet states, so the path found uses as few bombs as
+// any.  States are checked for a way to the goal as they're found.
+func (s *Solver) Solve() (out *SolveResult) {
+	var (
+		start   = &solveState{pos: s.spawn, bricks: make([]int, s.breakable)}
+		nodes   []solveNode
+		seen    map[string]bool
+		hazards = s.ignored()
+	)
+	out = &SolveResult{
+		Level:   s.Level.Path,
+		Ignored: append([]string{"more than one bomb at a time"}, hazards...),
+	}
+	s.lit = map[string]bool{}
+	for i, slot := range s.slots {
+		if slot >= 0 {
+			start.bricks[slot] = s.Level.tiles[i].Type
+		}
+	}
+	start.regions = s.regions(start.bricks)
+	if !s.open(start) {
+		out.Complete = true
+		return
+	}
+	nodes = []solveNode{{state: start, parent: -1}}
+	seen = map[string]bool{s.key(start): true}
+	out.Path = s.finish(nodes, 0)
+	for ; out.States < len(nodes) && out.Path == nil; out.States++ {
+		if out.States >= s.MaxStates {
+			return
+		}
+		for _, next := range s.moves(nodes[out.States].state) {
+			if key := s.key(next.state); !seen[key] {
+				seen[key] = true
+				next.parent = out.States
+				nodes = append(nodes, next)
+				if out.Path = s.finish(nodes, len(nodes)-1); out.Path != nil {
+					break
+				}
+			}
+		}
+	}
+	// Only plans with one bomb at a time are searched, so running out of
+	// them proves nothing about plans with more.
+	out.Solvable = out.Path != nil
+	// Anything left out of the search could still get in the path's way.
+	out.Complete = out.Solvable && len(hazards) == 0
+	out.Steps = len(out.Path)
+	out.Ticks = out.Steps * s.step
+	for _, step := range out.Path {
+		if len(step.Actions) > 0 && step.Actions[0] == ACTION_PLACE_BOMB {
+			out.Bombs++
+		}
+	}
+	return
+}
+
+// Whether the goal could be walked to with every brick broken.  If not
+// there's nothing to search.
+func (s *Solver) open(st *solveState) bool {
+	var bricks = make([]int, s.breakable)
+	for slot := range bricks {
+		bricks[slot] = TILE_GRASS
+	}
+	var regions = s.regions(bricks)
+	return regions[st.pos] == regions[s.goal]
+}
+
+// The whole path to the goal through node n, or nil if the goal can't be
+// walked to from there.
+func (s *Solver) finish(nodes []solveNode, n int) []SolveStep {
+	var st = nodes[n].state
+	if st.regions[st.pos] != st.regions[s.goal] {
+		return nil
+	}
+	var (
+		_, prev  = s.reach(st)
+		_, steps = s.walk(st, prev, s.goal)
+	)
+	if steps == nil {
+		return nil
+	}
+	return append(s.path(nodes, n), steps...)
+}
+
+// Quiet states reached by lighting a bomb somewhere the player can walk to,
+// or in loop mode by waiting to be sent back to the start.
+func (s *Solver) moves(st *solveState) (out []solveNode) {
+	if s.loop > 0 {
+		var next, _ = s.wait(st)
+		out = append(out, solveNode{state: next, site: -1})
+	}
+	var tiles, prev = s.reach(st)
+	for _, i := range tiles {
+		if !s.breaks(st, i) {
+			continue
+		}
+		var at, _ = s.walk(st, prev, i)
+		if at == nil || s.lit[s.spot(at)] {
+			continue
+		}
+		s.lit[s.spot(at)] = true
+		var ends, _ = s.blast(at)
+		for _, next := range ends {
+			out = append(out, solveNode{state: next, site: i})
+		}
+	}
+	return
+}
+
+// Works out again the steps moves took from a state to node n.
+func (s *Solver) retrace(st *solveState, n solveNode) (out []SolveStep) {
+	if n.site < 0 {
+		_, out = s.wait(st)
+		return
+	}
+	var (
+		_, prev     = s.reach(st)
+		at, steps   = s.walk(st, prev, n.site)
+		ends, trace = s.blast(at)
+	)
+	for i, end := range ends {
+		if end.pos == n.state.pos {
+			return append(steps, trace(i)...)
+		}
+	}
+	return nil
+}
+
+// Walks the shortest way to tile i, following prev from reach.  Returns
+// nil if there isn't one or if a respawn would get in the way.
+func (s *Solver) walk(st *solveState, prev map[int]int, i int) (out *solveState, steps []SolveStep) {
+	var (
+		from = st.pos
+		path []int
+	)
+	if _, ok := prev[i]; !ok {
+		return nil, nil
+	}
+	path = getPath(prev, st.pos, i)
+	if s.loop > 0 && st.timer+len(path)*s.step >= s.loop {
+		return nil, nil
+	}
+	out = &solveState{pos: i, timer: st.timer, bricks: st.bricks, regions: st.regions}
+	if s.loop > 0 {
+		out.timer += len(path) * s.step
+	}
+	steps = []SolveStep{}
+	for _, j := range path {
+		steps = append(steps, s.stepTo(from, j, false))
+		from = j
+	}
+	return
+}
+
+// Stands still until the loop sends the player back to the start.
+func (s *Solver) wait(st *solveState) (out *solveState, steps []SolveStep) {
+	out = &solveState{pos: s.spawn, timer: st.timer, bricks: st.bricks, regions: st.regions}
+	for out.timer < s.loop {
+		out.timer += s.step
+		steps = append(steps, s.stepTo(st.pos, st.pos, false))
+	}
+	out.timer -= s.loop
+	steps[len(steps)-1].Respawn = true
+	steps[len(steps)-1].X, steps[len(steps)-1].Y = s.Level.iToX(s.spawn), s.Level.iToY(s.spawn)
+	return
+}
+
+// Lights a bomb where the player stands and finds everywhere they can be
+// once it has gone off and the fire is out, a step at a time.  A lone bomb
+// always goes off at the same moment, so the fire is worked out up front.
+// Outside loop mode only one place is kept in each part of the map.  Call
+// trace with an index into out for the steps which got there.
+func (s *Solver) blast(st *solveState) (out []*solveState, trace func(int) []SolveStep) {
+	type move struct {
+		from   int
+		to     int // Where the step heads, before any respawn
+		pos    int
+		parent int // Index into the layer before
+	}
+	var (
+		count   = len(s.Level.tiles)
+		after   = append([]int{}, st.bricks...)
+		fire    = make([]bool, count)
+		seen    = make([]int, count) // The last depth each tile was reached at
+		first   = s.fuse / s.step    // The steps the fire burns through
+		last    = (s.fuse + s.burn - 1) / s.step
+		layers  = [][]move{{{pos: st.pos, parent: -1}}}
+		respawn = make([]bool, last+1)
+		timer   = st.timer
+		regions []int
+		kept    = map[int]bool{}
+		ends    []move
+	)
+	for _, i := range s.explode(after, st.pos) {
+		fire[i] = true
+	}
+	for depth := 0; depth <= last; depth++ {
+		var (
+			bricks  = st.bricks
+			layer   []move
+			burning = depth >= first
+		)
+		if depth > first {
+			bricks = after
+		}
+		if s.loop > 0 {
+			if timer += s.step; timer >= s.loop {
+				timer -= s.loop
+				respawn[depth] = true
+			}
+		}
+		for n, m := range layers[depth] {
+			for _, dir := range SOLVE_DIRS {
+				var to, pos = m.pos, m.pos
+				if dir != 0 {
+					var dx, dy = getDirectionStep(dir)
+					if to = s.neighbor(m.pos, dx, dy); to < 0 || !s.passable(bricks, to) || to == st.pos && depth <= first {
+						continue
+					}
+				}
+				if burning && (fire[m.pos] || fire[to]) {
+					continue
+				}
+				if pos = to; respawn[depth] {
+					if pos = s.spawn; burning && fire[pos] {
+						continue
+					}
+				}
+				if seen[pos] <= depth {
+					seen[pos] = depth + 1
+					layer = append(layer, move{m.pos, to, pos, n})
+				}
+			}
+		}
+		layers = append(layers, layer)
+	}
+	regions = s.regions(after)
+	for _, m := range layers[last+1] {
+		if s.loop == 0 {
+			if kept[regions[m.pos]] {
+				continue
+			}
+			kept[regions[m.pos]] = true
+		}
+		ends = append(ends, m)
+		out = append(out, &solveState{pos: m.pos, timer: timer, bricks: after, regions: regions})
+	}
+	trace = func(n int) []SolveStep {
+		var (
+			steps = make([]SolveStep, last+1)
+			m     = ends[n]
+		)
+		for depth := last; depth >= 0; depth-- {
+			steps[depth] = s.stepTo(m.from, m.to, depth == 0)
+			if respawn[depth] {
+				steps[depth].Respawn = true
+				steps[depth].X, steps[depth].Y = s.Level.iToX(m.pos), s.Level.iToY(m.pos)
+			}
+			if depth > 0 {
+				m = layers[depth][m.parent]
+			}
+		}
+		return steps
+	}
+	return
+}
+
+// Blasts outwards from a bomb at tile i, breaking bricks and stopping where
+// addFire would.  Returns every tile the fire reaches.
+func (s *Solver) explode(bricks []int, i int) (out []int) {
+	out = append(out, i)
+	for _, step := range [][]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+		for n := 1; n <= s.radius; n++ {
+			var j = s.neighbor(i, step[0]*n, step[1]*n)
+			if j < 0 {
+				break
+			}
+			var ttype = TILES[s.tileType(bricks, j)]
+			if !ttype.StopsFire {
+				out = append(out, j)
+				continue
+			}
+			if ttype.Breakable {
+				bricks[s.slots[j]] = ttype.NextState
+				out = append(out, j)
+			}
+			break
+		}
+	}
+	return
+}
+
+// Whether a bomb at tile i would break anything.
+func (s *Solver) breaks(st *solveState, i int) bool {
+	var after = append([]int{}, st.bricks...)
+	s.explode(after, i)
+	for slot, t := range after {
+		if t != st.bricks[slot] {
+			return true
+		}
+	}
+	return false
+}
+
+// Every tile the player can walk to, nearest first, and the tile each is
+// reached from.
+func (s *Solver) reach(st *solveState) (out []int, prev map[int]int) {
+	prev = map[int]int{st.pos: st.pos}
+	out = []int{st.pos}
+	for n := 0; n < len(out); n++ {
+		for _, j := range s.neighbors(st.bricks, out[n]) {
+			if _, ok := prev[j]; !ok {
+				prev[j] = out[n]
+				out = append(out, j)
+			}
+		}
+	}
+	return
+}
+
+// Labels each tile with the lowest index the player could walk to from it,
+// or -1 if it can't be stood on.
+func (s *Solver) regions(bricks []int) (out []int) {
+	out = make([]int, len(s.Level.tiles))
+	for i := range out {
+		out[i] = -1
+	}
+	for i := range out {
+		if out[i] >= 0 || !s.passable(bricks, i) {
+			continue
+		}
+		var queue = []int{i}
+		out[i] = i
+		for n := 0; n < len(queue); n++ {
+			for _, j := range s.neighbors(bricks, queue[n]) {
+				if out[j] < 0 {
+					out[j] = i
+					queue = append(queue, j)
+				}
+			}
+		}
+	}
+	return
+}
+
+func (s *Solver) neighbors(bricks []int, i int) (out []int) {
+	for _, dir := range SOLVE_DIRS[1:] {
+		var (
+			dx, dy = getDirectionStep(dir)
+			j      = s.neighbor(i, dx, dy)
+		)
+		if j >= 0 && s.passable(bricks, j) {
+			out = append(out, j)
+		}
+	}
+	return
+}
+
+func (s *Solver) neighbor(i int, dx int, dy int) int {
+	var (
+		l = s.Level
+		x = l.iToX(i) + dx
+		y = l.iToY(i) + dy
+	)
+	if x < 0 || y < 0 || x >= l.Map.Width || y >= l.Map.Height {
+		return -1
+	}
+	return l.xyToI(x, y)
+}
+
+func (s *Solver) tileType(bricks []int, i int) int {
+	if slot := s.slots[i]; slot >= 0 {
+		return bricks[slot]
+	}
+	return s.Level.tiles[i].Type
+}
+
+func (s *Solver) passable(bricks []int, i int) bool {
+	return TILES[s.tileType(bricks, i)].Passable
+}
+
+// Quiet states are the same if the same bricks are left and the player can
+// walk between them.  In loop mode where the player stands and how long
+// they have until the next respawn matter too.
+func (s *Solver) key(st *solveState) string {
+	if s.loop > 0 {
+		return s.spot(st)
+	}
+	return fmt.Sprintf("%s %v", s.pack(st.bricks), st.regions[st.pos])
+}
+
+// Exactly where and when the player is.
+func (s *Solver) spot(st *solveState) string {
+	return fmt.Sprintf("%s %v %v", s.pack(st.bricks), st.pos, st.timer)
+}
+
+func (s *Solver) pack(bricks []int) []byte {
+	var out = make([]byte, len(bricks))
+	for slot, t := range bricks {
+		out[slot] = byte(t)
+	}
+	return out
+}
+
+func (s *Solver) stepTo(from int, to int, bomb bool) (out SolveStep) {
+	var l = s.Level
+	out = SolveStep{X: l.iToX(to), Y: l.iToY(to)}
+	if bomb {
+		out.Actions = append(out.Actions, ACTION_PLACE_BOMB)
+	}
+	switch {
+	case to == from-1:
+		out.Actions = append(out.Actions, ACTION_MOVE_LEFT)
+	case to == from+1:
+		out.Actions = append(out.Actions, ACTION_MOVE_RIGHT)
+	case to == from-l.Map.Width:
+		out.Actions = append(out.Actions, ACTION_MOVE_UP)
+	case to == from+l.Map.Width:
+		out.Actions = append(out.Actions, ACTION_MOVE_DOWN)
+	}
+	return
+}
+
+// Walks back from a quiet state to list the steps taken to get there.
+func (s *Solver) path(nodes []solveNode, n int) (out []SolveStep) {
+	for ; n > 0; n = nodes[n].parent {
+		out = append(s.retrace(nodes[nodes[n].parent].state, nodes[n]), out...)
+	}
+	return
+}
+
+// Parts of the level the search leaves out which could still get in the
+// player's way.
+func (s *Solver) ignored() (out []string) {
+	var l = s.Level
+	if len(l.enemies) > 0 {
+		out = append(out, fmt.Sprintf("%v enemies", len(l.enemies)))
+	}
+	if l.Boss != nil {
+		out = append(out, "the boss")
+	}
+	for _, p := range l.pickups {
+		if p != nil {
+			out = append(out, "powerups")
+			break
+		}
+	}
+	return
+}
+
+// Turns a witness path into a replay of the level which can be watched or
+// run with -simulate.  Directions are held from one step to the next, and
+// pressed again after a respawn lets go of them.
+func (s *Solver) Replay(r *SolveResult, seed int64) (out *Replay) {
+	var held string
+	out = NewReplay(r.Level, seed, 1)
+	for n, step := range r.Path {
+		var (
+			tick = n * s.step
+			move string
+		)
+		for _, action := range step.Actions {
+			if action == ACTION_PLACE_BOMB {
+				out.Record(tick, action, true)
+			} else {
+				move = action
+			}
+		}
+		if move != held {
+			if held != "" {
+				out.Record(tick, held, false)
+			}
+			if move != "" {
+				out.Record(tick, move, true)
+			}
+			held = move
+		}
+		if step.Respawn {
+			held = ""
+		}
+	}
+	return
+}
+
+// Ticks run until a time is reached, counting the tick which passes it.
+func toTicks(d time.Duration) int {
+	var tick = time.Second / time.Duration(UPDATE_HZ)
+	return int((d + tick - 1) / tick)
+}
+
+// Loads a level by itself and searches it.  Nothing here needs a window.
+func SolveLevel(path string, seed int64) (out *SolveResult, solver *Solver, err error) {
+	var (
+		archetypes Archetypes
+		bosses     Bosses
+		level      *Level
+	)
+	if err = LoadSprites("data/animations.json"); err != nil {
+		return
+	}
+	if archetypes, err = LoadArchetypes("data/enemies.json"); err != nil {
+		return
+	}
+	if bosses, err = LoadBosses("data/bosses.json"); err != nil {
+		return
+	}
+	if level, err = LoadLevel(path, &Cast{}, archetypes, bosses, seed, func(string) {}); err != nil {
+		return
+	}
+	if solver, err = NewSolver(level); err != nil {
+		return
+	}
+	out = solver.Solve()
+	log.Printf("Searched %v quiet states of %v, solvable %v\n", out.States, path, out.Solvable)
+	return
+}
@@ -0,0 +1,73 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// Tracks which tiles each enemy's hitbox touches, so that lookups by tile
+// don't need to loop over every enemy in the level.
+type EnemyIndex struct {
+	cells [][]*Enemy
+	tiles map[*Enemy][]int
+}
+
+func NewEnemyIndex(count int) *EnemyIndex {
+	return &EnemyIndex{
+		cells: make([][]*Enemy, count),
+		tiles: map[*Enemy][]int{},
+	}
+}
+
+// Records the tiles an enemy now touches.
+func (x *EnemyIndex) Update(e *Enemy, tiles []int) {
+	if sameTiles(x.tiles[e], tiles) {
+		return
+	}
+	x.Remove(e)
+	for _, i := range tiles {
+		x.cells[i] = append(x.cells[i], e)
+	}
+	x.tiles[e] = tiles
+}
+
+func (x *EnemyIndex) Remove(e *Enemy) {
+	for _, i := range x.tiles[e] {
+		for j, other := range x.cells[i] {
+			if other == e {
+				x.cells[i] = append(x.cells[i][:j], x.cells[i][j+1:]...)
+				break
+			}
+		}
+	}
+	delete(x.tiles, e)
+}
+
+// Returns the enemies touching tile i.
+func (x *EnemyIndex) At(i int) []*Enemy {
+	if i < 0 || i >= len(x.cells) {
+		return nil
+	}
+	return x.cells[i]
+}
+
+func sameTiles(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,233 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	SPECTATE_TICKS   = 6 // Ticks between states sent to spectators
+	SPECTATE_TIMEOUT = 100 * time.Millisecond
+)
+
+// What spectators are sent.  Times are in milliseconds left to run and
+// tiles are indices into Tiles, which holds each tile's type.
+type SpectatorState struct {
+	Level   string
+	Tick    int
+	Width   int
+	Height  int
+	Tiles   []int
+	Players []SpectatorActor
+	Enemies []SpectatorActor
+	Boss    *SpectatorActor `json:",omitempty"`
+	Bombs   []SpectatorBomb
+	Fire    []SpectatorTimer
+	Pickups []SpectatorPickup
+	Loop    int64
+	Ending  int
+	Scores  []int `json:",omitempty"`
+}
+
+type SpectatorActor struct {
+	Index  int
+	X      float64
+	Y      float64
+	State  int
+	Health int
+}
+
+type SpectatorBomb struct {
+	Owner  int // The owning player's index, or -1 for enemies' bombs
+	X      float64
+	Y      float64
+	Fuse   int64
+	Flying bool
+}
+
+type SpectatorTimer struct {
+	Tile      int
+	Remaining int64
+}
+
+type SpectatorPickup struct {
+	Tile  int
+	Power int
+}
+
+// Streams states as lines of JSON to anyone connected over TCP.  Publishing
+// never waits, so a slow spectator can't hold up the level: states which
+// arrive while the last is still being sent are dropped, and spectators who
+// don't keep up are disconnected.
+type Spectators struct {
+	ln      net.Listener
+	clients []net.Conn
+	states  chan interface{}
+	joins   chan net.Conn
+	done    chan bool
+}
+
+func NewSpectators(addr string) (s *Spectators, err error) {
+	s = &Spectators{
+		states: make(chan interface{}, 1),
+		joins:  make(chan net.Conn),
+		done:   make(chan bool),
+	}
+	if s.ln, err = net.Listen("tcp", addr); err != nil {
+		return nil, err
+	}
+	log.Printf("Streaming to spectators on %v\n", addr)
+	go s.accept()
+	go s.run()
+	return
+}
+
+func (s *Spectators) Publish(state interface{}) {
+	select {
+	case s.states <- state:
+	default:
+	}
+}
+
+func (s *Spectators) Close() {
+	s.ln.Close()
+	close(s.done)
+}
+
+func (s *Spectators) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		log.Printf("Spectator joined from %v\n", conn.RemoteAddr())
+		select {
+		case s.joins <- conn:
+		case <-s.done:
+			conn.Close()
+			return
+		}
+	}
+}
+
+func (s *Spectators) run() {
+	for {
+		select {
+		case conn := <-s.joins:
+			s.clients = append(s.clients, conn)
+		case state := <-s.states:
+			s.send(state)
+		case <-s.done:
+			for _, conn := range s.clients {
+				conn.Close()
+			}
+			return
+		}
+	}
+}
+
+func (s *Spectators) send(state interface{}) {
+	var (
+		data []byte
+		err  error
+	)
+	if len(s.clients) == 0 {
+		return
+	}
+	if data, err = json.Marshal(state); err != nil {
+		log.Printf("Couldn't encode state for spectators: %v\n", err)
+		return
+	}
+	data = append(data, '\n')
+	for i := len(s.clients) - 1; i >= 0; i-- {
+		var conn = s.clients[i]
+		conn.SetWriteDeadline(time.Now().Add(SPECTATE_TIMEOUT))
+		if _, err = conn.Write(data); err != nil {
+			log.Printf("Spectator %v left: %v\n", conn.RemoteAddr(), err)
+			conn.Close()
+			s.clients = append(s.clients[:i], s.clients[i+1:]...)
+		}
+	}
+}
+
+// Copies out everything a spectator needs, so the copy can be encoded on
+// another goroutine while the level carries on.
+func (l *Level) SpectatorState() (s *SpectatorState) {
+	s = &SpectatorState{
+		Level:  l.Path,
+		Tick:   l.Ticks,
+		Width:  l.Map.Width,
+		Height: l.Map.Height,
+		Tiles:  make([]int, len(l.tiles)),
+		Loop:   millis(l.LoopRemaining()),
+		Ending: l.ending,
+	}
+	for i, t := range l.tiles {
+		s.Tiles[i] = t.Type
+	}
+	for _, p := range l.Players {
+		s.Players = append(s.Players, spectateActor(p.Index, p))
+	}
+	for i, e := range l.enemies {
+		s.Enemies = append(s.Enemies, spectateActor(i, e.Player))
+	}
+	if l.Boss != nil && !l.Boss.Defeated() {
+		var boss = spectateActor(0, l.Boss.Player)
+		s.Boss = &boss
+	}
+	for _, b := range append(l.getBombs(), l.loose...) {
+		var bomb = SpectatorBomb{
+			Owner:  -1,
+			X:      b.X(),
+			Y:      b.Y(),
+			Fuse:   millis(b.Expires - b.Elapsed),
+			Flying: b.Flying,
+		}
+		if b.owner != nil {
+			// Still set once the owner has been knocked out of a battle.
+			bomb.Owner = b.owner.Index
+		}
+		s.Bombs = append(s.Bombs, bomb)
+	}
+	for i, f := range l.fire {
+		if f != nil {
+			s.Fire = append(s.Fire, SpectatorTimer{i, millis(f.Expires - f.Elapsed)})
+		}
+	}
+	for i, p := range l.pickups {
+		if p != nil {
+			s.Pickups = append(s.Pickups, SpectatorPickup{i, p.Power})
+		}
+	}
+	return
+}
+
+func spectateActor(index int, p *Player) SpectatorActor {
+	return SpectatorActor{
+		Index:  index,
+		X:      p.X(),
+		Y:      p.Y(),
+		State:  p.State,
+		Health: p.Health,
+	}
+}
+
+func millis(d time.Duration) int64 {
+	return d.Nanoseconds() / int64(time.Millisecond)
+}
@@ -0,0 +1,169 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"./system"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How a single animation is written in the data file.  Duration is in
+// milliseconds and applies to every frame unless Durations lists one per
+// frame.  Tags are keyed by position in Frames.
+type AnimationDef struct {
+	Frames    []int
+	Duration  int
+	Durations []int
+	Mode      string
+	Tags      map[int]string
+}
+
+// A row of the actor texture and the animations for each state, keyed by
+// state names joined with "|", e.g. "left|walking".
+type SpriteDef struct {
+	TextureRow int
+	Animations map[string]*AnimationDef
+}
+
+type AnimationFile struct {
+	Actors map[string]*SpriteDef
+	Tiles  map[string]*AnimationDef
+}
+
+type Sprite struct {
+	TextureRow int
+	Anims      map[int]*system.Animation
+	missing    map[int]bool // States already logged as having no animation
+	mutex      sync.Mutex
+}
+
+// Logs that the sprite has no animation for state, the first time only.
+// Actors are animated and painted from different goroutines.
+func (s *Sprite) reportMissing(name string, state int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.missing[state] {
+		return
+	}
+	s.missing[state] = true
+	log.Printf("No animation for sprite %v in state %v\n", name, state)
+}
+
+type Sprites map[string]*Sprite
+
+// Sprites for every kind of actor, filled in by LoadSprites.
+var SPRITES = Sprites{}
+
+// Loads actor sprites and tile animations.  Tile animations replace the
+// ones on TILES.
+func LoadSprites(path string) (err error) {
+	var (
+		file   AnimationFile
+		out    = Sprites{}
+		anim   *system.Animation
+		sprite *Sprite
+		state  int
+	)
+	log.Printf("Loading animations from %v\n", path)
+	if err = loadJSON(path, &file); err != nil {
+		return
+	}
+	for name, def := range file.Actors {
+		sprite = &Sprite{
+			TextureRow: def.TextureRow,
+			Anims:      map[int]*system.Animation{},
+			missing:    map[int]bool{},
+		}
+		for key, adef := range def.Animations {
+			if state, err = ParseState(key); err != nil {
+				return fmt.Errorf("Sprite %v: %v", name, err)
+			}
+			if sprite.Anims[state], err = adef.getAnimation(); err != nil {
+				return fmt.Errorf("Sprite %v, state %v: %v", name, key, err)
+			}
+		}
+		out[name] = sprite
+	}
+	for name, def := range file.Tiles {
+		var (
+			tile int
+			ok   bool
+		)
+		if tile, ok = TILE_NAMES[name]; !ok {
+			return fmt.Errorf("Unknown tile %v", name)
+		}
+		if anim, err = def.getAnimation(); err != nil {
+			return fmt.Errorf("Tile %v: %v", name, err)
+		}
+		ttype := TILES[tile]
+		ttype.Anim = system.NewAnimationPlayer(anim)
+		TILES[tile] = ttype
+	}
+	SPRITES = out
+	return
+}
+
+// Converts state names joined with "|" into a State bitmask.
+func ParseState(key string) (state int, err error) {
+	for _, name := range strings.Split(key, "|") {
+		var (
+			bit int
+			ok  bool
+		)
+		if bit, ok = STATES[strings.TrimSpace(name)]; !ok {
+			return 0, fmt.Errorf("Unknown state %v", name)
+		}
+		state |= bit
+	}
+	return
+}
+
+func (d *AnimationDef) getAnimation() (anim *system.Animation, err error) {
+	if len(d.Frames) == 0 {
+		return nil, fmt.Errorf("Animation has no frames")
+	}
+	if d.Durations != nil && len(d.Durations) != len(d.Frames) {
+		return nil, fmt.Errorf("Got %v durations for %v frames", len(d.Durations), len(d.Frames))
+	}
+	anim = &system.Animation{
+		Frames: make([]system.Frame, len(d.Frames)),
+	}
+	switch d.Mode {
+	case "", "loop":
+		anim.Mode = system.AnimLoop
+	case "once":
+		anim.Mode = system.AnimOnce
+	case "pingpong":
+		anim.Mode = system.AnimPingPong
+	default:
+		return nil, fmt.Errorf("Unknown animation mode %v", d.Mode)
+	}
+	for i, index := range d.Frames {
+		var ms = d.Duration
+		if d.Durations != nil {
+			ms = d.Durations[i]
+		}
+		anim.Frames[i] = system.Frame{
+			Index:    index,
+			Duration: time.Duration(ms) * time.Millisecond,
+			Tag:      d.Tags[i],
+		}
+	}
+	return
+}
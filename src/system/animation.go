@@ -14,35 +14,147 @@
 
 package system
 
+import (
+	"time"
+)
+
+// Playback modes.
+const (
+	AnimLoop = iota
+	AnimOnce
+	AnimPingPong
+)
+
+// A single frame of an animation.  Tagged frames notify the player when
+// they're reached.
+type Frame struct {
+	Index    int
+	Duration time.Duration
+	Tag      string
+}
+
+// Describes an animation.  Animations hold no playback state so they can be
+// shared between any number of AnimationPlayers.
 type Animation struct {
-	Frames  []int
-	Repeat  int
-	current int
-	counter int
+	Frames []Frame
+	Mode   int
 }
 
-func Anim(frames []int, repeat int) *Animation {
-	return &Animation{
-		Frames:  frames,
-		Repeat:  repeat,
-		current: 0,
-		counter: 0,
+// Creates a looping animation where every frame lasts the same time.
+func Anim(frames []int, duration time.Duration) *Animation {
+	var a = &Animation{
+		Frames: make([]Frame, len(frames)),
+		Mode:   AnimLoop,
+	}
+	for i, index := range frames {
+		a.Frames[i] = Frame{Index: index, Duration: duration}
 	}
+	return a
 }
 
 func (a *Animation) Len() int {
 	return len(a.Frames)
 }
 
-func (a *Animation) Curr() int {
-	return a.Frames[a.current]
+// Called when a tagged frame is reached.
+type FrameTagHandler func(tag string)
+
+// Called when an animation finishes, or completes a cycle if it repeats.
+type AnimationDoneHandler func()
+
+// Plays back an Animation over time.
+type AnimationPlayer struct {
+	Anim    *Animation
+	OnTag   FrameTagHandler
+	OnDone  AnimationDoneHandler
+	Done    bool
+	current int
+	elapsed time.Duration
+	reverse bool
+}
+
+func NewAnimationPlayer(anim *Animation) *AnimationPlayer {
+	return &AnimationPlayer{
+		Anim: anim,
+	}
+}
+
+// Switches to a different animation, restarting from its first frame.
+// Playing the current animation again does nothing.
+func (p *AnimationPlayer) Play(anim *Animation) {
+	if p.Anim == anim {
+		return
+	}
+	p.Anim = anim
+	p.Restart()
+}
+
+func (p *AnimationPlayer) Restart() {
+	p.current = 0
+	p.elapsed = 0
+	p.reverse = false
+	p.Done = false
+	p.tag()
+}
+
+func (p *AnimationPlayer) Curr() int {
+	return p.Anim.Frames[p.current].Index
+}
+
+// Advances the animation by the given amount of time.
+func (p *AnimationPlayer) Update(diff time.Duration) {
+	if p.Done || p.Anim.Len() == 0 {
+		return
+	}
+	p.elapsed += diff
+	for !p.Done {
+		var duration = p.Anim.Frames[p.current].Duration
+		if duration <= 0 || p.elapsed < duration {
+			return
+		}
+		p.elapsed -= duration
+		p.advance()
+	}
+}
+
+func (p *AnimationPlayer) advance() {
+	var last = p.Anim.Len() - 1
+	switch {
+	case p.Anim.Mode == AnimOnce && p.current == last:
+		p.Done = true
+		p.done()
+		return
+	case p.Anim.Mode == AnimPingPong && last > 0:
+		if p.reverse && p.current == 0 || !p.reverse && p.current == last {
+			p.reverse = !p.reverse
+		}
+		if p.reverse {
+			p.current -= 1
+		} else {
+			p.current += 1
+		}
+		if p.current == 0 {
+			p.done()
+		}
+	default:
+		if p.current = (p.current + 1) % p.Anim.Len(); p.current == 0 {
+			p.done()
+		}
+	}
+	p.tag()
+}
+
+func (p *AnimationPlayer) tag() {
+	if p.OnTag == nil || p.Anim.Len() == 0 {
+		return
+	}
+	if tag := p.Anim.Frames[p.current].Tag; tag != "" {
+		p.OnTag(tag)
+	}
 }
 
-func (a *Animation) Next() int {
-	a.counter += 1
-	if a.counter > a.Repeat {
-		a.current = (a.current + 1) % a.Len()
-		a.counter = 0
+func (p *AnimationPlayer) done() {
+	if p.OnDone != nil {
+		p.OnDone()
 	}
-	return a.Frames[a.current]
 }
@@ -106,6 +106,27 @@ func (c *Controller) SetKeyCallback(handler KeyHandler) {
 	glfw.SetKeyCallback(glfw.KeyHandler(handler))
 }
 
+// Reads a joystick, pads count from 0.  Returns false if it isn't plugged
+// in.
+func (c *Controller) ReadGamepad(pad int) (state GamepadState, ok bool) {
+	var (
+		joy     = glfw.Joystick1 + pad
+		buttons []byte
+	)
+	if pad >= MaxGamepads || glfw.JoystickParam(joy, glfw.Present) == 0 {
+		return
+	}
+	state.Axes = make([]float32, glfw.JoystickParam(joy, glfw.Axes))
+	glfw.JoystickPos(joy, state.Axes)
+	buttons = make([]byte, glfw.JoystickParam(joy, glfw.Buttons))
+	glfw.JoystickButtons(joy, buttons)
+	state.Buttons = make([]bool, len(buttons))
+	for i, b := range buttons {
+		state.Buttons[i] = b != 0
+	}
+	return state, true
+}
+
 // Check whether a key is pressed.
 func (c *Controller) Key(key int) int {
 	return glfw.Key(key)
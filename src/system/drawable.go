@@ -22,6 +22,11 @@ type Drawable interface {
 	TextureRow() int
 }
 
+// Drawables which cover more than one tile.
+type Scalable interface {
+	Scale() int
+}
+
 type Drawables []Drawable
 
 func (s Drawables) Len() int      { return len(s) }
@@ -0,0 +1,155 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"fmt"
+)
+
+// Gamepad buttons and axis directions get key codes above the keyboard's
+// so they can be bound to actions like any other key.  Each pad has
+// GamepadKeys codes, buttons first and then two per axis.
+const (
+	KeyGamepad      = 1024
+	GamepadKeys     = 64
+	GamepadButtons  = 32
+	MaxGamepads     = 4
+	DefaultDeadzone = 0.25
+)
+
+func GamepadButton(pad int, button int) int {
+	return KeyGamepad + pad*GamepadKeys + button
+}
+
+func GamepadAxis(pad int, axis int, positive bool) int {
+	var key = KeyGamepad + pad*GamepadKeys + GamepadButtons + axis*2
+	if positive {
+		key += 1
+	}
+	return key
+}
+
+// Which pad a key is on, or -1 for the keyboard.
+func KeyPad(key int) int {
+	if key >= KeyGamepad && key < KeyGamepad+MaxGamepads*GamepadKeys {
+		return (key - KeyGamepad) / GamepadKeys
+	}
+	return -1
+}
+
+// Parses names like "pad1_button0" or "pad1_axis1-".  Pads count from 1 up
+// to MaxGamepads.
+func parseGamepadKey(name string) (key int, err error) {
+	var (
+		pad  int
+		n    int
+		sign rune
+	)
+	if _, err = fmt.Sscanf(name, "pad%d", &pad); err == nil && (pad < 1 || pad > MaxGamepads) {
+		return KeyUnknown, fmt.Errorf("Unknown key %v, pads run from 1 to %v", name, MaxGamepads)
+	}
+	if _, err = fmt.Sscanf(name, "pad%d_button%d", &pad, &n); err == nil && n >= 0 && n < GamepadButtons {
+		return GamepadButton(pad-1, n), nil
+	}
+	if _, err = fmt.Sscanf(name, "pad%d_axis%d%c", &pad, &n, &sign); err == nil && n >= 0 &&
+		(sign == '+' || sign == '-') && GamepadButtons+n*2 < GamepadKeys {
+		return GamepadAxis(pad-1, n, sign == '+'), nil
+	}
+	return KeyUnknown, fmt.Errorf("Unknown key %v", name)
+}
+
+func gamepadKeyName(key int) string {
+	var (
+		pad = (key - KeyGamepad) / GamepadKeys
+		n   = (key - KeyGamepad) % GamepadKeys
+	)
+	if n < GamepadButtons {
+		return fmt.Sprintf("pad%v_button%v", pad+1, n)
+	}
+	n -= GamepadButtons
+	if n%2 == 1 {
+		return fmt.Sprintf("pad%v_axis%v+", pad+1, n/2)
+	}
+	return fmt.Sprintf("pad%v_axis%v-", pad+1, n/2)
+}
+
+// Anything which feeds events into an InputMap when polled.
+type InputSource interface {
+	Poll(m *InputMap)
+}
+
+// A snapshot of a gamepad.  Axes run from -1 to 1.
+type GamepadState struct {
+	Axes    []float32
+	Buttons []bool
+}
+
+// Where gamepad state comes from.  The Controller reads real joysticks,
+// tests can supply their own.
+type GamepadReader interface {
+	ReadGamepad(pad int) (state GamepadState, ok bool)
+}
+
+// Turns changes in a gamepad's state into key events.  Axes count as
+// pressed once they're pushed past Deadzone.
+type Gamepad struct {
+	Index    int
+	Deadzone float32
+	Reader   GamepadReader
+	down     map[int]bool
+}
+
+func NewGamepad(index int, reader GamepadReader) *Gamepad {
+	return &Gamepad{
+		Index:    index,
+		Deadzone: DefaultDeadzone,
+		Reader:   reader,
+		down:     map[int]bool{},
+	}
+}
+
+func (g *Gamepad) Poll(m *InputMap) {
+	var (
+		state GamepadState
+		ok    bool
+		now   = map[int]bool{}
+	)
+	if state, ok = g.Reader.ReadGamepad(g.Index); ok {
+		for i, pressed := range state.Buttons {
+			if pressed && i < GamepadButtons {
+				now[GamepadButton(g.Index, i)] = true
+			}
+		}
+		for i, v := range state.Axes {
+			switch {
+			case v > g.Deadzone:
+				now[GamepadAxis(g.Index, i, true)] = true
+			case v < -g.Deadzone:
+				now[GamepadAxis(g.Index, i, false)] = true
+			}
+		}
+	}
+	for key := range g.down {
+		if !now[key] {
+			m.KeyEvent(key, 0)
+		}
+	}
+	for key := range now {
+		if !g.down[key] {
+			m.KeyEvent(key, 1)
+		}
+	}
+	g.down = now
+}
@@ -0,0 +1,223 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Names for keys in binding files.  Letters and digits are named by
+// themselves, e.g. "w" or "1".
+var KeyNames = map[string]int{
+	"space":     KeySpace,
+	"esc":       KeyEsc,
+	"f1":        KeyF1,
+	"f2":        KeyF2,
+	"f3":        KeyF3,
+	"f4":        KeyF4,
+	"f5":        KeyF5,
+	"f6":        KeyF6,
+	"f7":        KeyF7,
+	"f8":        KeyF8,
+	"f9":        KeyF9,
+	"f10":       KeyF10,
+	"f11":       KeyF11,
+	"f12":       KeyF12,
+	"up":        KeyUp,
+	"down":      KeyDown,
+	"left":      KeyLeft,
+	"right":     KeyRight,
+	"lshift":    KeyLshift,
+	"rshift":    KeyRshift,
+	"lctrl":     KeyLctrl,
+	"rctrl":     KeyRctrl,
+	"lalt":      KeyLalt,
+	"ralt":      KeyRalt,
+	"tab":       KeyTab,
+	"enter":     KeyEnter,
+	"backspace": KeyBackspace,
+	"insert":    KeyInsert,
+	"del":       KeyDel,
+	"pageup":    KeyPageup,
+	"pagedown":  KeyPagedown,
+	"home":      KeyHome,
+	"end":       KeyEnd,
+	"pause":     KeyPause,
+}
+
+func ParseKey(name string) (key int, err error) {
+	var ok bool
+	name = strings.ToLower(name)
+	if key, ok = KeyNames[name]; ok {
+		return
+	}
+	if strings.HasPrefix(name, "pad") {
+		return parseGamepadKey(name)
+	}
+	if len(name) == 1 && (name[0] >= 'a' && name[0] <= 'z' || name[0] >= '0' && name[0] <= '9') {
+		// Letter keys are reported as upper case.
+		return int(strings.ToUpper(name)[0]), nil
+	}
+	return KeyUnknown, fmt.Errorf("Unknown key %v", name)
+}
+
+func KeyName(key int) string {
+	if key >= KeyGamepad && key < KeyGamepad+MaxGamepads*GamepadKeys {
+		return gamepadKeyName(key)
+	}
+	for name, k := range KeyNames {
+		if k == key {
+			return name
+		}
+	}
+	if key > 32 && key < 127 {
+		return strings.ToLower(string(rune(key)))
+	}
+	return fmt.Sprintf("key%v", key)
+}
+
+// Called when a key starts or stops the actions bound to it.
+type ActionHandler func(actions []string, pressed bool)
+
+// Maps keys to named actions.  Game code reacts to actions and never needs
+// to know which keys produced them.
+type InputMap struct {
+	Bindings map[string][]int
+	Handler  ActionHandler
+	held     map[int]bool
+	capture  KeyHandler
+}
+
+func NewInputMap(bindings map[string][]int) *InputMap {
+	return &InputMap{
+		Bindings: bindings,
+		held:     map[int]bool{},
+	}
+}
+
+// Loads bindings written as action names mapped to lists of key names.
+func LoadInputMap(path string) (m *InputMap, err error) {
+	var (
+		f     *os.File
+		names map[string][]string
+	)
+	log.Printf("Loading controls from %v\n", path)
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+	if err = json.NewDecoder(f).Decode(&names); err != nil {
+		return
+	}
+	m = NewInputMap(map[string][]int{})
+	for action, keys := range names {
+		for _, name := range keys {
+			var key int
+			if key, err = ParseKey(name); err != nil {
+				return nil, fmt.Errorf("Action %v: %v", action, err)
+			}
+			m.Bindings[action] = append(m.Bindings[action], key)
+		}
+	}
+	return
+}
+
+func (m *InputMap) Save(path string) (err error) {
+	var (
+		f     *os.File
+		data  []byte
+		names = map[string][]string{}
+	)
+	for action, keys := range m.Bindings {
+		for _, key := range keys {
+			names[action] = append(names[action], KeyName(key))
+		}
+	}
+	if data, err = json.MarshalIndent(names, "", " "); err != nil {
+		return
+	}
+	if f, err = os.Create(path); err != nil {
+		return
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return
+}
+
+// Binds the action to key in place of its first key on the same keyboard
+// or pad, so its other keys stay bound.  Other actions keep the key if
+// they had it, the way place_bomb and confirm share space.
+func (m *InputMap) Rebind(action string, key int) {
+	var keys = m.Bindings[action]
+	for i, k := range keys {
+		if KeyPad(k) == KeyPad(key) {
+			keys[i] = key
+			return
+		}
+	}
+	m.Bindings[action] = append(keys, key)
+}
+
+// Returns the actions bound to a key, sorted so they always come in the
+// same order.
+func (m *InputMap) Actions(key int) (out []string) {
+	for action, keys := range m.Bindings {
+		for _, k := range keys {
+			if k == key {
+				out = append(out, action)
+				break
+			}
+		}
+	}
+	sort.Strings(out)
+	return
+}
+
+// Whether any key bound to the action is held down.
+func (m *InputMap) Held(action string) bool {
+	for _, key := range m.Bindings[action] {
+		if m.held[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// Sends the next key press to handler instead of turning it into actions.
+func (m *InputMap) Capture(handler KeyHandler) {
+	m.capture = handler
+}
+
+// Feeds a key event in, state is 1 for a press and 0 for a release.
+func (m *InputMap) KeyEvent(key int, state int) {
+	if m.capture != nil && state == 1 {
+		var capture = m.capture
+		m.capture = nil
+		capture(key, state)
+		return
+	}
+	m.held[key] = state == 1
+	if m.Handler == nil {
+		return
+	}
+	if actions := m.Actions(key); len(actions) > 0 {
+		m.Handler(actions, state == 1)
+	}
+}
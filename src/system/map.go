@@ -71,6 +71,7 @@ type TiledMap struct {
 	Tilewidth   int
 	Version     int
 	Width       int
+	dir         string
 }
 
 func LoadMap(path string) (out *TiledMap, err error) {
@@ -88,18 +89,31 @@ func LoadMap(path string) (out *TiledMap, err error) {
 		return
 	}
 	for i, ts := range tm.Tilesets {
-		tspath := filepath.Join(filepath.Dir(path), ts.Image)
-		if tm.Tilesets[i].Texture, err = LoadTexture(tspath, IntNearest, ts.Tilewidth, ts.Tileheight); err != nil {
-			return
-		}
 		// The following ignores spacing, but I don't use it.
 		tm.Tilesets[i].Tilecount = (ts.Imagewidth / ts.Tilewidth) * (ts.Imageheight / ts.Tileheight)
 		tm.Tilesets[i].Lastgid = ts.Firstgid + tm.Tilesets[i].Tilecount
 	}
+	tm.dir = filepath.Dir(path)
 	out = &tm
 	return
 }
 
+// Loads the image of each tileset which doesn't have a texture yet.  Maps
+// are only parsed by LoadMap, so this needs calling from wherever there is
+// a GL context before the map gets painted.
+func (m *TiledMap) LoadTextures() (err error) {
+	for i, ts := range m.Tilesets {
+		if ts.Texture != nil {
+			continue
+		}
+		tspath := filepath.Join(m.dir, ts.Image)
+		if m.Tilesets[i].Texture, err = LoadTexture(tspath, IntNearest, ts.Tilewidth, ts.Tileheight); err != nil {
+			return
+		}
+	}
+	return
+}
+
 func (m *TiledMap) GetLayer(t string, n string) (out *TiledLayer, err error) {
 	for i, l := range m.Layers {
 		if l.Type == t && l.Name == n {
@@ -0,0 +1,245 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type Color struct {
+	R float32
+	G float32
+	B float32
+	A float32
+}
+
+// Blends towards o, t runs from 0 to 1.
+func (c Color) Lerp(o Color, t float32) Color {
+	return Color{
+		R: c.R + (o.R-c.R)*t,
+		G: c.G + (o.G-c.G)*t,
+		B: c.B + (o.B-c.B)*t,
+		A: c.A + (o.A-c.A)*t,
+	}
+}
+
+// Drawables which are tinted when painted.
+type Tinted interface {
+	Color() Color
+}
+
+// Describes the particles an emitter gives off.  Each particle heads off at
+// Angle, give or take Spread, at Speed plus up to SpeedRange.  Angles are
+// in radians with 0 pointing right and positive angles pointing down.
+type EmitterConfig struct {
+	Count      int // Particles per burst
+	Lifetime   time.Duration
+	Speed      float64 // Pixels per second
+	SpeedRange float64 // Pixels per second
+	Angle      float64
+	Spread     float64
+	Gravity    float64 // Pixels per second per second
+	Scale      float64 // Size relative to a full sprite
+	From       Color
+	To         Color
+	Anim       *Animation
+	TextureRow int
+}
+
+type Particle struct {
+	x       float64
+	y       float64
+	vx      float64
+	vy      float64
+	elapsed time.Duration
+	config  *EmitterConfig
+	anim    *AnimationPlayer
+}
+
+func (p *Particle) X() float64 {
+	return p.x
+}
+
+func (p *Particle) Y() float64 {
+	return p.y
+}
+
+func (p *Particle) GetFrame() int {
+	if p.anim == nil {
+		return 0
+	}
+	return p.anim.Curr()
+}
+
+func (p *Particle) FlipX() bool {
+	return false
+}
+
+func (p *Particle) TextureRow() int {
+	return p.config.TextureRow
+}
+
+func (p *Particle) Size() float64 {
+	return p.config.Scale
+}
+
+// The particle's color, fading from From to To over its lifetime.
+func (p *Particle) Color() Color {
+	return p.config.From.Lerp(p.config.To, float32(p.age()))
+}
+
+func (p *Particle) Expired() bool {
+	return p.elapsed >= p.config.Lifetime
+}
+
+// How far through its lifetime the particle is, from 0 to 1.
+func (p *Particle) age() float64 {
+	if p.config.Lifetime <= 0 {
+		return 1
+	}
+	return math.Min(1, p.elapsed.Seconds()/p.config.Lifetime.Seconds())
+}
+
+func (p *Particle) update(diff time.Duration) {
+	var secs = diff.Seconds()
+	p.elapsed += diff
+	p.vy += p.config.Gravity * secs
+	p.x += p.vx * secs
+	p.y += p.vy * secs
+	if p.anim != nil {
+		p.anim.Update(diff)
+	}
+}
+
+// Emits particles at Rate per second for Duration, or until removed if
+// Duration is 0.
+type Emitter struct {
+	Config   *EmitterConfig
+	X        float64
+	Y        float64
+	Rate     float64
+	Duration time.Duration
+	elapsed  time.Duration
+	owed     float64
+}
+
+func (e *Emitter) Expired() bool {
+	return e.Duration > 0 && e.elapsed >= e.Duration
+}
+
+// Owns every live particle.  Once Max particles are alive new ones replace
+// the oldest.  Particles are updated on one goroutine and painted from
+// another, so they're only touched under mutex and painters take a Snapshot.
+type ParticleSystem struct {
+	Emitters  []*Emitter
+	Max       int
+	particles []*Particle
+	mutex     sync.Mutex
+	rng       *rand.Rand
+}
+
+func NewParticleSystem(max int, rng *rand.Rand) *ParticleSystem {
+	return &ParticleSystem{
+		Max:       max,
+		particles: make([]*Particle, 0, max),
+		rng:       rng,
+	}
+}
+
+// Copies of the live particles, safe to paint while the system updates.
+func (s *ParticleSystem) Snapshot() (out []Particle) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	out = make([]Particle, len(s.particles))
+	for i, p := range s.particles {
+		if out[i] = *p; p.anim != nil {
+			var anim = *p.anim
+			out[i].anim = &anim
+		}
+	}
+	return
+}
+
+func (s *ParticleSystem) AddEmitter(e *Emitter) {
+	s.Emitters = append(s.Emitters, e)
+}
+
+func (s *ParticleSystem) RemoveEmitter(e *Emitter) {
+	for i, emitter := range s.Emitters {
+		if emitter == e {
+			s.Emitters = append(s.Emitters[:i], s.Emitters[i+1:]...)
+			break
+		}
+	}
+}
+
+// Emits config.Count particles at once.
+func (s *ParticleSystem) Burst(config *EmitterConfig, x float64, y float64) {
+	for n := 0; n < config.Count; n++ {
+		s.emit(config, x, y)
+	}
+}
+
+func (s *ParticleSystem) Update(diff time.Duration) {
+	for i := len(s.Emitters) - 1; i >= 0; i-- {
+		var e = s.Emitters[i]
+		e.elapsed += diff
+		e.owed += e.Rate * diff.Seconds()
+		for ; e.owed >= 1; e.owed -= 1 {
+			s.emit(e.Config, e.X, e.Y)
+		}
+		if e.Expired() {
+			s.Emitters = append(s.Emitters[:i], s.Emitters[i+1:]...)
+		}
+	}
+	var live = make([]*Particle, 0, s.Max)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, p := range s.particles {
+		if p.update(diff); !p.Expired() {
+			live = append(live, p)
+		}
+	}
+	s.particles = live
+}
+
+func (s *ParticleSystem) emit(config *EmitterConfig, x float64, y float64) {
+	var (
+		angle = config.Angle + (s.rng.Float64()*2-1)*config.Spread
+		speed = config.Speed + s.rng.Float64()*config.SpeedRange
+		p     = &Particle{
+			x:      x,
+			y:      y,
+			vx:     math.Cos(angle) * speed,
+			vy:     math.Sin(angle) * speed,
+			config: config,
+		}
+	)
+	if s.Max <= 0 {
+		return
+	}
+	if config.Anim != nil && config.Anim.Len() > 0 {
+		p.anim = NewAnimationPlayer(config.Anim)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.particles) >= s.Max {
+		s.particles = append(make([]*Particle, 0, s.Max), s.particles[1:]...)
+	}
+	s.particles = append(s.particles, p)
+}
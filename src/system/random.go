@@ -0,0 +1,43 @@
+// Copyright 2013 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+)
+
+// Hands out random streams derived from one seed.  Each stream's seed comes
+// from the name it's asked for under and how many were asked for before it,
+// so one kind of consumer never shifts the numbers another gets.
+type Random struct {
+	Seed   int64
+	counts map[string]int
+}
+
+func NewRandom(seed int64) *Random {
+	return &Random{
+		Seed:   seed,
+		counts: map[string]int{},
+	}
+}
+
+func (r *Random) Stream(name string) *rand.Rand {
+	var h = fnv.New64a()
+	fmt.Fprint(h, r.Seed, ":", name, ":", r.counts[name])
+	r.counts[name]++
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}